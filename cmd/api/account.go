@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/VladimirArtyom/rest_eiga_api/internal/data"
+	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
+)
+
+// Tout ce que l'on garde sur l'utilisateur courant, dans un fichier JSON (RGPD)
+func (app *application) exportCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	pendingEmail, err := app.models.Users.GetPendingEmail(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	family, err := app.currentSessionFamily(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sessions, err := app.models.Tokens.GetAllSessionsForUser(user.ID, family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	apiKeys, err := app.models.APIKeys.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	identities, err := app.models.Identities.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	twoFactorEnabled, err := app.models.TwoFactors.IsEnabled(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	reviews, err := app.models.Reviews.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	watchlist, err := app.models.Watchlist.ExportForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	watched, err := app.models.Watched.ExportForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	export := payload{
+		"exported_at":        time.Now().UTC(),
+		"user":               user,
+		"pending_email":      pendingEmail,
+		"permissions":        permissions,
+		"roles":              roles,
+		"sessions":           sessions,
+		"api_keys":           apiKeys,
+		"identities":         identities,
+		"two_factor_enabled": twoFactorEnabled,
+		"reviews":            reviews,
+		"watchlist":          watchlist,
+		"watched":            watched,
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="greenmemes-export-%d.json"`, user.ID))
+	headers.Set("Cache-Control", "no-store")
+
+	err = app.writeJSON(w, export, headers, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// Supprime le compte courant apres verification du mot de passe
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	type email_data struct {
+		Email string
+		Name  string
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidatePasswordPlainText(v, input.Password); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// L'utilisateur du contexte peut venir d'un JWT, sans mot de passe
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !app.verifyCurrentPassword(w, r, user, input.Password) {
+		return
+	}
+
+	err = app.models.WithTx(func(tx data.Models) error {
+		err := tx.Users.Delete(user.ID)
+		if err != nil {
+			return err
+		}
+
+		// Les echecs de connexion sont gardes par email, sans cle etrangere
+		err = tx.LoginAttempts.Clear(user.Email)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionDelete, "user", user.ID, nil, nil)
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.models.Permissions.Cache.Invalidate(user.ID)
+
+	out := &email_data{
+		Email: user.Email,
+		Name:  user.Name,
+	}
+
+	app.background(func(v interface{}) {
+		user, _ := v.(email_data)
+		err := app.mailer.Send(user.Email, "account_deleted.tmpl", user)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	}, *out)
+
+	err = app.writeJSON(w, payload{"message": "your account and all its data were successfully deleted"}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
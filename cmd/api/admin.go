@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/VladimirArtyom/rest_eiga_api/internal/data"
+	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
+)
+
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Search string
+		Filter data.Filters
+	}
+
+	var v *validator.Validator = validator.New()
+	parameters := r.URL.Query()
+
+	input.Search = app.readString(parameters, "search", "")
+
+	input.Filter.Page = app.readInt(parameters, "page", 1, v)
+	input.Filter.PageSize = app.readInt(parameters, "page_size", 20, v)
+	input.Filter.Sort = app.readString(parameters, "sort", "id")
+	input.Filter.SupportedSortList = []string{
+		"id", "name", "email", "created_at",
+		"-id", "-name", "-email", "-created_at",
+	}
+
+	if data.ValidateFilters(v, input.Filter); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.GetAll(input.Search, input.Filter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, payload{
+		"metadata": metadata,
+		"users":    users},
+		nil,
+		http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserFromIDParameter(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, payload{"user": user}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) listUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserFromIDParameter(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+func (app *application) grantUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserFromIDParameter(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestErrorResponse(w, r, err)
+		return
+	}
+
+	knownPermissions, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Permissions) > 0, "permissions", "must contain at least 1 permission")
+	for _, code := range input.Permissions {
+		v.Check(knownPermissions.Include(code), "permissions", fmt.Sprintf("unknown permission code %q", code))
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.WithTx(func(tx data.Models) error {
+		err := tx.Permissions.AddForUser(user.ID, input.Permissions...)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionGrant, "permission", user.ID, nil, payload{"permissions": input.Permissions})
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+func (app *application) revokeUserPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserFromIDParameter(w, r)
+	if !ok {
+		return
+	}
+
+	code := app.readParameter(r, "code")
+
+	err := app.models.WithTx(func(tx data.Models) error {
+		err := tx.Permissions.RemoveForUser(user.ID, code)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionRevoke, "permission", user.ID, payload{"permissions": []string{code}}, nil)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+func (app *application) updateUserActivationHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserFromIDParameter(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Activated *bool `json:"activated"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Activated != nil, "activated", "must be provided")
+	v.Check(user.ID != app.contextGetUser(r).ID, "activated", "you cannot change the activation of your own account")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	before := *user
+	user.Activated = *input.Activated
+
+	app.updateUserAsAdmin(w, r, before, user)
+}
+
+func (app *application) updateUserLockHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUserFromIDParameter(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Locked *bool `json:"locked"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Locked != nil, "locked", "must be provided")
+	v.Check(user.ID != app.contextGetUser(r).ID, "locked", "you cannot lock your own account")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	before := *user
+	user.Locked = *input.Locked
+
+	app.updateUserAsAdmin(w, r, before, user)
+}
+
+// Enregistre l'utilisateur modifie par un administrateur. Un compte verrouille
+// ou desactive perd toutes ses sessions.
+func (app *application) updateUserAsAdmin(w http.ResponseWriter, r *http.Request, before data.User, user *data.User) {
+	err := app.models.WithTx(func(tx data.Models) error {
+		err := tx.Users.Update(user)
+		if err != nil {
+			return err
+		}
+
+		if user.Locked || !user.Activated {
+			err = tx.Tokens.DeleteAllForUser(user.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return app.audit(tx, r, auditActionUpdate, "user", user.ID, nil, auditUserChanges(&before, user))
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, payload{"user": user}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) writeUserPermissions(w http.ResponseWriter, r *http.Request, user *data.User) {
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, payload{"user_id": user.ID, "permissions": permissions}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
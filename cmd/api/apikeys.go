@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/VladimirArtyom/rest_eiga_api/internal/data"
+	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
+)
+
+func (app *application) listUserAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	keys, err := app.models.APIKeys.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, payload{"api_keys": keys}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) createUserAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string     `json:"name"`
+		Permissions []string   `json:"permissions"`
+		Expiry      *time.Time `json:"expiry"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestErrorResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	var key *data.APIKey = &data.APIKey{
+		Name:        input.Name,
+		Permissions: input.Permissions,
+		Expiry:      input.Expiry,
+	}
+
+	v := validator.New()
+	data.ValidateAPIKey(v, key)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// La cle ne recoit qu'un sous-ensemble des permissions du proprietaire
+	ownerPermissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, code := range key.Permissions {
+		if !ownerPermissions.Include(code) {
+			v.AddError("permissions", fmt.Sprintf("you do not have the %q permission", code))
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.WithTx(func(tx data.Models) error {
+		var err error
+		key, err = tx.APIKeys.New(user.ID, key.Name, key.Permissions, key.Expiry)
+		if err != nil {
+			return err
+		}
+
+		// Jamais la cle en clair dans le journal
+		logged := *key
+		logged.Plaintext = ""
+		return app.audit(tx, r, auditActionCreate, "api_key", key.ID, nil, logged)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateAPIKeyName):
+			v.AddError("name", "an API key with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// La cle en clair n'est montree qu'une seule fois
+	err = app.writeJSON(w, payload{"api_key": key}, nil, http.StatusCreated)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) deleteUserAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParameter(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.WithTx(func(tx data.Models) error {
+		err := tx.APIKeys.DeleteForUser(id, user.ID)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionDelete, "api_key", id, nil, nil)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, payload{"message": "API key successfully deleted"}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
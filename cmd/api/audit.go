@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/VladimirArtyom/rest_eiga_api/internal/data"
+	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
+)
+
+// Actions enregistrees dans le journal d'audit
+const (
+	auditActionCreate  = "create"
+	auditActionUpdate  = "update"
+	auditActionDelete  = "delete"
+	auditActionRestore = "restore"
+	auditActionRevert  = "revert"
+	auditActionGrant   = "grant"
+	auditActionRevoke  = "revoke"
+	auditActionLogin   = "login"
+	auditActionLogout  = "logout"
+)
+
+// Enregistre une operation d'ecriture avec tx, les models de la transaction qui
+// l'effectue (Models.WithTx): la modification n'est validee qu'avec son entree.
+// before et after sont serialises en JSON (nil pour aucun) et ne doivent
+// contenir aucun secret ni aucune donnee personnelle: le journal ne peut pas
+// etre efface.
+func (app *application) audit(tx data.Models, r *http.Request, action string, resourceType string, resourceID interface{}, before interface{}, after interface{}) error {
+	entry := &data.AuditEntry{
+		Action:       action,
+		ResourceType: resourceType,
+		RequestID:    app.contextGetRequestID(r),
+		IP:           app.clientIP(r),
+	}
+
+	if resourceID != nil {
+		entry.ResourceID = fmt.Sprint(resourceID)
+	}
+
+	user := app.contextGetUser(r)
+	if !user.IsAnonymous() {
+		entry.ActorID = &user.ID
+	}
+
+	var err error
+	if before != nil {
+		entry.Before, err = json.Marshal(before)
+		if err != nil {
+			return err
+		}
+	}
+
+	if after != nil {
+		entry.After, err = json.Marshal(after)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Audit.Insert(entry)
+}
+
+// Les noms des champs modifies, sans leurs valeurs
+func auditChangedFields(fields map[string]bool) payload {
+	changed := []string{}
+	for name, isChanged := range fields {
+		if isChanged {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+
+	return payload{"changed": changed}
+}
+
+// Nom et email sont des donnees personnelles, seuls les champs modifies sont journalises
+func auditUserChanges(before *data.User, after *data.User) payload {
+	return auditChangedFields(map[string]bool{
+		"name":      before.Name != after.Name,
+		"email":     before.Email != after.Email,
+		"activated": before.Activated != after.Activated,
+		"locked":    before.Locked != after.Locked,
+	})
+}
+
+func (app *application) listAuditHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Filter  data.AuditFilter
+		Filters data.Filters
+	}
+
+	v := validator.New()
+	parameters := r.URL.Query()
+
+	input.Filter.ActorID = int64(app.readInt(parameters, "actor_id", 0, v))
+	input.Filter.Action = app.readString(parameters, "action", "")
+	input.Filter.ResourceType = app.readString(parameters, "resource_type", "")
+	input.Filter.ResourceID = app.readString(parameters, "resource_id", "")
+	input.Filter.RequestID = app.readString(parameters, "request_id", "")
+	input.Filter.Since = app.readTime(parameters, "since", v)
+	input.Filter.Until = app.readTime(parameters, "until", v)
+
+	input.Filters.Page = app.readInt(parameters, "page", 1, v)
+	input.Filters.PageSize = app.readInt(parameters, "page_size", 20, v)
+	input.Filters.Sort = app.readString(parameters, "sort", "-created_at")
+	input.Filters.SupportedSortList = []string{"id", "created_at", "-id", "-created_at"}
+
+	v.Check(input.Filter.ActorID >= 0, "actor_id", "must be a positive integer")
+	if !input.Filter.Since.IsZero() && !input.Filter.Until.IsZero() {
+		v.Check(input.Filter.Since.Before(input.Filter.Until), "until", "must be after since")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.models.Audit.GetAll(input.Filter, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, payload{"metadata": metadata, "audit": entries}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
@@ -10,6 +10,10 @@ import (
 type contextKey string
 
 const userContextKey = contextKey("user")
+const tokenContextKey = contextKey("token")
+const permissionsContextKey = contextKey("permissions")
+const requestIDContextKey = contextKey("request_id")
+const apiKeyContextKey = contextKey("api_key")
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	
@@ -25,4 +29,56 @@ func (app *application) contextGetUser(r *http.Request) *data.User{
 	return user
 }
 
+// Le token en clair utilise pour authentifier la requete
+func (app *application) contextSetToken(r *http.Request, token string) *http.Request {
 
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetToken(r *http.Request) string {
+	token, ok := r.Context().Value(tokenContextKey).(string)
+	if !ok {
+		return ""
+	}
+	return token
+}
+
+// Les permissions deja connues pour la requete (par exemple celles d'un JWT),
+// requirePermission n'a alors pas besoin de la base de donnees
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetPermissions(r *http.Request) (data.Permissions, bool) {
+	permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions)
+	return permissions, ok
+}
+
+func (app *application) contextSetRequestID(r *http.Request, requestID string) *http.Request {
+
+	ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetRequestID(r *http.Request) string {
+	requestID, ok := r.Context().Value(requestIDContextKey).(string)
+	if !ok {
+		return ""
+	}
+	return requestID
+}
+
+// La cle d'API qui a authentifie la requete, le cas echeant
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetAPIKey(r *http.Request) (*data.APIKey, bool) {
+	key, ok := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key, ok
+}
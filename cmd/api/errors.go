@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -10,9 +13,24 @@ func (app *application) logError(r *http.Request, err error) {
 	app.logger.PrintError(err, map[string]string{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
+		"request_id":     app.contextGetRequestID(r),
 	})
 }
 
+// Les evenements de securite (echecs de connexion, verrouillages...) sont
+// des lignes INFO avec une propriete "event", faciles a filtrer
+func (app *application) logSecurityEvent(r *http.Request, event string, properties map[string]string) {
+	if properties == nil {
+		properties = map[string]string{}
+	}
+	properties["event"] = event
+	properties["request_method"] = r.Method
+	properties["request_url"] = r.URL.String()
+	properties["user_agent"] = r.UserAgent()
+
+	app.logger.PrintInfo("security event", properties)
+}
+
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, err_message interface{}) {
 
 	payload_data := payload{"error": err_message}
@@ -80,6 +98,31 @@ func (app *application) invalidAuthenticationTokenResponse( w http.ResponseWrite
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+func (app *application) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or expired API key"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) apiKeyNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource cannot be accessed with an API key, please authenticate with a token"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) invalidRefreshTokenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid, expired or revoked refresh token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) invalidOIDCStateResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or expired login state, please start the login again"
+	app.errorResponse(w, r, http.StatusBadRequest, message)
+}
+
+func (app *application) invalidOIDCLoginResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the login with the identity provider could not be verified"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r* http.Request){
 	
 	message := "You have to be authenticated to access this resource"	
@@ -92,6 +135,32 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r* http.R
 	app.errorResponse(w,r, http.StatusForbidden, message)
 }
 
+func (app *application) lockedAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "Your user account has been locked, please contact an administrator"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) loginThrottledResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	setRetryAfter(w, retryAfter)
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+func (app *application) temporarilyLockedAccountResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	setRetryAfter(w, retryAfter)
+	message := "this account is temporarily locked after too many failed login attempts, check your email to unlock it"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// Retry-After en secondes, au moins une
+func setRetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
 func (app *application) notPermittedResponse(w http.ResponseWriter, r* http.Request) {
 	message := "Your user account doesn't have the necessary permission to access this resource"
 	app.errorResponse(w,r, http.StatusForbidden, message)
@@ -5,10 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/VladimirArtyom/rest_eiga_api/internal/jsonlog"
 	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
@@ -26,6 +28,36 @@ func (app *application) readIDParameter(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// Comme readIDParameter, pour un autre identifiant de la route, par exemple :review_id
+func (app *application) readNamedIDParameter(r *http.Request, name string) (int64, error) {
+	id, err := strconv.ParseInt(app.readParameter(r, name), 10, 64)
+	if err != nil || id < 1 {
+		return -1, fmt.Errorf("Invalid %s parameter", name)
+	}
+	return id, nil
+}
+
+// Lire un parametre de la route, par exemple :role
+func (app *application) readParameter(r *http.Request, name string) string {
+	params := httprouter.ParamsFromContext(r.Context())
+	return params.ByName(name)
+}
+
+// Faux si l'en-tete X-Expected-Version est present et differe de version
+func (app *application) expectedVersionMatches(r *http.Request, version int32) bool {
+	expected := r.Header.Get("X-Expected-Version")
+	return expected == "" || expected == strconv.FormatInt(int64(version), 10)
+}
+
+// L'adresse IP du client, sans le port
+func (app *application) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
 func parseAllowedOrigins(origins string) map[string]bool {
 	trustedOrigins := map[string]bool{}
 	for _, origin := range strings.Split(origins, ",") {
@@ -145,6 +177,22 @@ func (app *application) readString(parameters url.Values, key string, defaultVal
 	return value
 }
 
+// Lit un parametre RFC 3339, zero s'il est absent
+func (app *application) readTime(parameters url.Values, key string, v *validator.Validator) time.Time {
+	value := parameters.Get(key)
+	if value == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		v.AddError(key, "must be a RFC 3339 timestamp")
+		return time.Time{}
+	}
+
+	return t
+}
+
 //Backroundの関数
 func (app *application) background(fn func(params interface{}), arg interface{}) {
 
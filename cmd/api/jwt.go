@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/base64"
+	"strconv"
+
+	"github.com/VladimirArtyom/rest_eiga_api/internal/data"
+	"github.com/VladimirArtyom/rest_eiga_api/internal/jwt"
+)
+
+// Retrouve l'utilisateur du token. La signature ne suffit pas: le compte doit
+// toujours exister et la famille du token ne doit pas avoir ete revoquee.
+func (app *application) userForClaims(claims *jwt.Claims) (*data.User, error) {
+	id, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil || id < 1 {
+		return nil, jwt.ErrInvalidToken
+	}
+
+	family, err := familyFromClaims(claims)
+	if err != nil || len(family) == 0 {
+		return nil, jwt.ErrInvalidToken
+	}
+
+	return app.models.Users.GetForTokenFamily(id, family)
+}
+
+// Signs an access token. The permissions are only informative for the client,
+// requirePermission reads them from the permission cache.
+func (app *application) newAuthenticationJWT(user *data.User, family []byte) (*data.Token, error) {
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.Claims{
+		Subject:     strconv.FormatInt(user.ID, 10),
+		Name:        user.Name,
+		Email:       user.Email,
+		Activated:   user.Activated,
+		Permissions: *permissions,
+		Family:      base64.RawURLEncoding.EncodeToString(family),
+	}
+
+	signed, expiry, err := app.jwtKeys.Sign(claims, app.cfg.tokens.authenticationTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.Token{
+		Plaintext: signed,
+		UserID:    user.ID,
+		Expiry:    expiry,
+		Scope:     data.ScopeAuthentication,
+		Family:    family,
+	}, nil
+}
+
+// La famille du refresh token emis avec ce JWT
+func familyFromClaims(claims *jwt.Claims) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(claims.Family)
+}
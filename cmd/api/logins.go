@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/VladimirArtyom/rest_eiga_api/internal/data"
+	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
+)
+
+// Nombre d'echecs autorises avant que les delais commencent
+const loginFreeAttempts = 3
+
+// Le delai minimum entre deux tentatives apres n echecs: 1s, 2s, 4s... jusqu'a une minute
+func loginDelay(failures int) time.Duration {
+	if failures < loginFreeAttempts {
+		return 0
+	}
+
+	delay := time.Duration(math.Pow(2, float64(failures-loginFreeAttempts))) * time.Second
+	if delay > time.Minute {
+		return time.Minute
+	}
+	return delay
+}
+
+// Verifie si cet email peut tenter une connexion maintenant.
+// Si ok est false, la reponse a deja ete envoyee.
+func (app *application) checkLoginThrottle(w http.ResponseWriter, r *http.Request, email string) bool {
+	ip := app.clientIP(r)
+
+	lockedUntil, err := app.models.LoginAttempts.LockedUntil(email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if lockedUntil != nil {
+		app.logSecurityEvent(r, "auth.login_locked", map[string]string{
+			"email":        email,
+			"ip":           ip,
+			"locked_until": lockedUntil.UTC().Format(time.RFC3339),
+		})
+		app.temporarilyLockedAccountResponse(w, r, time.Until(*lockedUntil))
+		return false
+	}
+
+	failures, err := app.models.LoginAttempts.Failures(email, ip, app.cfg.login.window)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	// Trop d'echecs depuis cette IP pour ce compte: on bloque l'IP, pas le compte
+	if failures.IP >= app.cfg.login.maxFailuresPerIP {
+		app.logSecurityEvent(r, "auth.login_throttled", map[string]string{
+			"email":    email,
+			"ip":       ip,
+			"failures": strconv.Itoa(failures.IP),
+			"reason":   "too many failures from this ip",
+		})
+		app.loginThrottledResponse(w, r, time.Until(failures.LastFailure.Add(app.cfg.login.window)))
+		return false
+	}
+
+	wait := loginDelay(failures.Account) - time.Since(failures.LastFailure)
+	if wait > 0 {
+		app.logSecurityEvent(r, "auth.login_throttled", map[string]string{
+			"email":    email,
+			"ip":       ip,
+			"failures": strconv.Itoa(failures.Account),
+			"reason":   "progressive delay",
+		})
+		app.loginThrottledResponse(w, r, wait)
+		return false
+	}
+
+	return true
+}
+
+// Verifie le mot de passe de l'utilisateur connecte avant une action sensible.
+// Un echec compte comme un echec de connexion, avec les memes delais et le meme
+// verrouillage. Si ok est false, la reponse a deja ete envoyee.
+func (app *application) verifyCurrentPassword(w http.ResponseWriter, r *http.Request, user *data.User, password string) bool {
+	if !app.checkLoginThrottle(w, r, user.Email) {
+		return false
+	}
+
+	isMatched, err := user.Password.Matches(password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !isMatched {
+		err = app.recordLoginFailure(r, user.Email, user, "invalid current password")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
+		app.invalidCredentialResponse(w, r)
+		return false
+	}
+
+	err = app.models.LoginAttempts.Clear(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	return true
+}
+
+// Enregistre un echec de connexion. Apres trop d'echecs le compte est verrouille
+// pour un moment et un email de deverrouillage est envoye au proprietaire.
+func (app *application) recordLoginFailure(r *http.Request, email string, user *data.User, reason string) error {
+	ip := app.clientIP(r)
+
+	err := app.models.LoginAttempts.RecordFailure(email, ip)
+	if err != nil {
+		return err
+	}
+
+	failures, err := app.models.LoginAttempts.Failures(email, ip, app.cfg.login.window)
+	if err != nil {
+		return err
+	}
+
+	app.logSecurityEvent(r, "auth.login_failed", map[string]string{
+		"email":    email,
+		"ip":       ip,
+		"reason":   reason,
+		"failures": strconv.Itoa(failures.Account),
+	})
+
+	if failures.Account < app.cfg.login.maxFailures {
+		return nil
+	}
+
+	lockedUntil := time.Now().Add(app.cfg.login.lockoutDuration)
+
+	err = app.models.LoginAttempts.Lock(email, lockedUntil)
+	if err != nil {
+		return err
+	}
+
+	app.logSecurityEvent(r, "auth.account_locked", map[string]string{
+		"email":        email,
+		"ip":           ip,
+		"failures":     strconv.Itoa(failures.Account),
+		"locked_until": lockedUntil.UTC().Format(time.RFC3339),
+	})
+
+	// Pas d'email si le compte n'existe pas
+	if user == nil {
+		return nil
+	}
+
+	type email_data struct {
+		UnlockToken string
+		Email       string
+		LockedUntil string
+	}
+
+	err = app.models.Tokens.DeleteAllTokensForUser(data.ScopeUnlock, user.ID)
+	if err != nil {
+		return err
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeUnlock)
+	if err != nil {
+		return err
+	}
+
+	out := &email_data{
+		UnlockToken: token.Plaintext,
+		Email:       user.Email,
+		LockedUntil: lockedUntil.UTC().Format(time.RFC1123),
+	}
+
+	app.background(func(v interface{}) {
+		user, _ := v.(email_data)
+		err := app.mailer.Send(user.Email, "account_locked.tmpl", user)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	}, *out)
+
+	return nil
+}
+
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		TokenPlainText string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestErrorResponse(w, r, err)
+		return
+	}
+
+	var v *validator.Validator = validator.New()
+
+	if data.ValidateToken(v, input.TokenPlainText); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeUnlock, input.TokenPlainText)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired unlock token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.WithTx(func(tx data.Models) error {
+		err := tx.LoginAttempts.Clear(user.Email)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Tokens.DeleteAllTokensForUser(data.ScopeUnlock, user.ID)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionDelete, "login_lockout", user.ID, nil, nil)
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logSecurityEvent(r, "auth.account_unlocked", map[string]string{
+		"email": user.Email,
+		"ip":    app.clientIP(r),
+	})
+
+	err = app.writeJSON(w, payload{"message": "your account was successfully unlocked"}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"database/sql"
+	"expvar"
 	"flag"
+	"fmt"
 	"os"
 	"strings"
 	"sync"
@@ -11,13 +13,22 @@ import (
 
 	"github.com/VladimirArtyom/rest_eiga_api/internal/data"
 	"github.com/VladimirArtyom/rest_eiga_api/internal/jsonlog"
+	"github.com/VladimirArtyom/rest_eiga_api/internal/jwt"
 	"github.com/VladimirArtyom/rest_eiga_api/internal/mailer"
+	"github.com/VladimirArtyom/rest_eiga_api/internal/oidc"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
 )
 
 const version string = "1.0.0"
 
+// Modes d'authentification
+const (
+	authModeToken = "token" // tokens opaques stockes dans la table tokens
+	authModeJWT   = "jwt"   // JWT signes, le compte, la famille et les permissions sont reverifies
+)
+
 type config struct {
 	port int
 	env  string
@@ -42,6 +53,54 @@ type config struct {
 		password string
 		sender string
 	}
+	tokens struct {
+		authenticationTTL time.Duration
+		refreshTTL        time.Duration
+	}
+	auth struct {
+		mode string
+	}
+	jwt struct {
+		keys        string
+		activeKeyID string
+		issuer      string
+	}
+	permissions struct {
+		cacheTTL time.Duration
+	}
+	login struct {
+		maxFailures      int
+		maxFailuresPerIP int
+		window           time.Duration
+		lockoutDuration  time.Duration
+	}
+	totp struct {
+		issuer string
+	}
+	password struct {
+		minLength     int
+		requireUpper  bool
+		requireLower  bool
+		requireDigit  bool
+		requireSymbol bool
+		maxRepeated   int
+		checkBreached bool
+		hasher        string
+		bcryptCost    int
+		argon2Memory  int
+		argon2Time    int
+		argon2Threads int
+	}
+	trash struct {
+		retention     time.Duration
+		purgeInterval time.Duration
+	}
+	oidc struct {
+		issuer       string
+		clientID     string
+		clientSecret string
+		redirectURL  string
+	}
 }
 
 type application struct {
@@ -49,6 +108,9 @@ type application struct {
 	logger *jsonlog.Logger
 	models data.Models
 	mailer *mailer.Mailer
+	jwtKeys *jwt.KeySet
+	passwordPolicy data.PasswordPolicy
+	oidc *oidc.Provider // nil quand la connexion OIDC n'est pas configuree
 	wg sync.WaitGroup
 }
 
@@ -92,6 +154,52 @@ func main() {
 	flag.StringVar(&cfg.smtp.password, "smpt-password", os.Getenv("SMTP_PASSWORD"), "The password of the mail server")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", os.Getenv("SMTP_SENDER"), "The sender of the mail")
 
+	// Duree de vie des tokens
+	flag.DurationVar(&cfg.tokens.authenticationTTL, "auth-token-ttl", 15*time.Minute, "Lifetime of an authentication (access) token")
+	flag.DurationVar(&cfg.tokens.refreshTTL, "refresh-token-ttl", 30*24*time.Hour, "Lifetime of a refresh token")
+
+	// Authentification: tokens opaques ou JWT
+	flag.StringVar(&cfg.auth.mode, "auth-mode", authModeToken, "Authentication mode (token|jwt)")
+	flag.StringVar(&cfg.jwt.keys, "jwt-keys", os.Getenv("JWT_KEYS"), "JWT keys as kid:alg:base64key, separated by COMMA (alg is HS256 or EdDSA)")
+	flag.StringVar(&cfg.jwt.activeKeyID, "jwt-active-kid", os.Getenv("JWT_ACTIVE_KID"), "The kid of the key used to sign new JWTs")
+	flag.StringVar(&cfg.jwt.issuer, "jwt-issuer", "rest_eiga_api", "The issuer (iss) of the JWTs")
+
+	flag.DurationVar(&cfg.permissions.cacheTTL, "permissions-cache-ttl", time.Minute, "How long the permissions of a user are cached (0 disables the cache)")
+
+	// Protection contre le brute force sur le login
+	flag.IntVar(&cfg.login.maxFailures, "login-max-failures", 10, "Failed logins for an account before it is temporarily locked")
+	flag.IntVar(&cfg.login.maxFailuresPerIP, "login-max-failures-per-ip", 5, "Failed logins for an account from a single IP before that IP is throttled")
+	flag.DurationVar(&cfg.login.window, "login-failure-window", 15*time.Minute, "Window in which failed logins are counted")
+	flag.DurationVar(&cfg.login.lockoutDuration, "login-lockout-duration", 30*time.Minute, "How long an account stays locked after too many failed logins")
+
+	flag.StringVar(&cfg.totp.issuer, "totp-issuer", "Greenmemes", "The issuer shown in authenticator apps")
+
+	// Politique des nouveaux mots de passe
+	flag.IntVar(&cfg.password.minLength, "password-min-length", data.DefaultPasswordPolicy.MinLength, "Minimum length of a new password (at least 8)")
+	flag.BoolVar(&cfg.password.requireUpper, "password-require-upper", data.DefaultPasswordPolicy.RequireUpper, "Require an uppercase letter in new passwords")
+	flag.BoolVar(&cfg.password.requireLower, "password-require-lower", data.DefaultPasswordPolicy.RequireLower, "Require a lowercase letter in new passwords")
+	flag.BoolVar(&cfg.password.requireDigit, "password-require-digit", data.DefaultPasswordPolicy.RequireDigit, "Require a digit in new passwords")
+	flag.BoolVar(&cfg.password.requireSymbol, "password-require-symbol", data.DefaultPasswordPolicy.RequireSymbol, "Require a symbol in new passwords")
+	flag.IntVar(&cfg.password.maxRepeated, "password-max-repeated", data.DefaultPasswordPolicy.MaxRepeated, "Maximum identical characters in a row in new passwords (0 disables)")
+	flag.BoolVar(&cfg.password.checkBreached, "password-check-breached", data.DefaultPasswordPolicy.CheckBreached, "Refuse new passwords found in the bundled breached password list")
+
+	// Algorithme des nouveaux hashes, les anciens sont mis a jour au login
+	flag.StringVar(&cfg.password.hasher, "password-hasher", "bcrypt", "Password hashing algorithm for new hashes (bcrypt|argon2id)")
+	flag.IntVar(&cfg.password.bcryptCost, "bcrypt-cost", 12, "bcrypt cost")
+	flag.IntVar(&cfg.password.argon2Memory, "argon2-memory", 64*1024, "argon2id memory in KiB")
+	flag.IntVar(&cfg.password.argon2Time, "argon2-iterations", 3, "argon2id iterations")
+	flag.IntVar(&cfg.password.argon2Threads, "argon2-parallelism", 2, "argon2id parallelism")
+
+	// Corbeille des films
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "How long deleted movies stay in the trash before being purged (0 disables the purge)")
+	flag.DurationVar(&cfg.trash.purgeInterval, "trash-purge-interval", time.Hour, "How often the trash is purged")
+
+	// Connexion via un fournisseur d'identite externe (desactivee sans issuer)
+	flag.StringVar(&cfg.oidc.issuer, "oidc-issuer", os.Getenv("OIDC_ISSUER"), "The OpenID Connect issuer URL (empty disables OIDC login)")
+	flag.StringVar(&cfg.oidc.clientID, "oidc-client-id", os.Getenv("OIDC_CLIENT_ID"), "The OpenID Connect client ID")
+	flag.StringVar(&cfg.oidc.clientSecret, "oidc-client-secret", os.Getenv("OIDC_CLIENT_SECRET"), "The OpenID Connect client secret (empty for a public client)")
+	flag.StringVar(&cfg.oidc.redirectURL, "oidc-redirect-url", os.Getenv("OIDC_REDIRECT_URL"), "The URL of /v1/auth/oidc/callback, as registered with the provider")
+
 	// Allowed origins
 	flag.Func("cors-trusted-origin", "Trusted origins, separated by COMMA", func(origins string) error {
 		
@@ -107,6 +215,56 @@ func main() {
 	}
 	flag.Parse()
 
+	var jwtKeys *jwt.KeySet
+	switch cfg.auth.mode {
+	case authModeToken:
+	case authModeJWT:
+		jwtKeys, err = jwt.ParseKeys(cfg.jwt.keys, cfg.jwt.activeKeyID, cfg.jwt.issuer)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	default:
+		logger.PrintFatal(fmt.Errorf("invalid auth mode %q", cfg.auth.mode), nil)
+	}
+
+	switch cfg.password.hasher {
+	case "bcrypt":
+		if cfg.password.bcryptCost < bcrypt.MinCost || cfg.password.bcryptCost > bcrypt.MaxCost {
+			logger.PrintFatal(fmt.Errorf("bcrypt-cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost), nil)
+		}
+		data.SetPasswordHasher(data.BcryptHasher{Cost: cfg.password.bcryptCost})
+	case "argon2id":
+		if cfg.password.argon2Memory < 8 || cfg.password.argon2Time < 1 || cfg.password.argon2Threads < 1 || cfg.password.argon2Threads > 255 {
+			logger.PrintFatal(fmt.Errorf("invalid argon2id parameters"), nil)
+		}
+		data.SetPasswordHasher(data.Argon2idHasher{
+			Memory:      uint32(cfg.password.argon2Memory),
+			Iterations:  uint32(cfg.password.argon2Time),
+			Parallelism: uint8(cfg.password.argon2Threads),
+		})
+	default:
+		logger.PrintFatal(fmt.Errorf("invalid password hasher %q", cfg.password.hasher), nil)
+	}
+
+	if cfg.trash.retention > 0 && cfg.trash.purgeInterval <= 0 {
+		logger.PrintFatal(fmt.Errorf("trash-purge-interval must be positive"), nil)
+	}
+
+	var oidcProvider *oidc.Provider
+	if cfg.oidc.issuer != "" {
+		if cfg.oidc.clientID == "" || cfg.oidc.redirectURL == "" {
+			logger.PrintFatal(fmt.Errorf("oidc-client-id and oidc-redirect-url are required with oidc-issuer"), nil)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		oidcProvider, err = oidc.Discover(ctx, cfg.oidc.issuer, cfg.oidc.clientID, cfg.oidc.clientSecret, cfg.oidc.redirectURL)
+		cancel()
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		logger.PrintInfo("oidc provider discovered", map[string]string{"issuer": cfg.oidc.issuer})
+	}
+
 	// Init database
 	db, err := openDB(cfg)
 	if err != nil {
@@ -116,15 +274,34 @@ func main() {
 
 	logger.PrintInfo("database connection pool is established", nil)
 
+	models := data.NewModels(db)
+
+	permissionCache := data.NewPermissionCache(cfg.permissions.cacheTTL)
+	models.SetPermissionCache(permissionCache)
+	expvar.Publish("permissions_cache", expvar.Func(func() interface{} {
+		return permissionCache.Stats()
+	}))
+
 	var app *application = &application{
 		cfg:    cfg,
 		logger: logger,
-		models: data.NewModels(db),
+		models: models,
 		mailer: mailer.New(cfg.smtp.host,
 						cfg.smtp.port,
 						cfg.smtp.username,
 						cfg.smtp.password,
 						cfg.smtp.sender),
+		jwtKeys: jwtKeys,
+		passwordPolicy: data.PasswordPolicy{
+			MinLength:     cfg.password.minLength,
+			RequireUpper:  cfg.password.requireUpper,
+			RequireLower:  cfg.password.requireLower,
+			RequireDigit:  cfg.password.requireDigit,
+			RequireSymbol: cfg.password.requireSymbol,
+			MaxRepeated:   cfg.password.maxRepeated,
+			CheckBreached: cfg.password.checkBreached,
+		},
+		oidc: oidcProvider,
 	}
 
 
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"regexp"
 	"strings"
 	"sync"
 	"time" 
 	"github.com/VladimirArtyom/rest_eiga_api/internal/data"
+	"github.com/VladimirArtyom/rest_eiga_api/internal/jwt"
 	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
 	"golang.org/x/time/rate"
 )
@@ -27,6 +31,29 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
+var requestIDRX = regexp.MustCompile("^[A-Za-z0-9._-]{1,64}$")
+
+// Reprend l'en-tete X-Request-Id du client s'il est raisonnable, sinon en genere un
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-Id")
+		if !validator.Matches(requestID, requestIDRX) {
+			b := make([]byte, 16)
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			requestID = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-Id", requestID)
+		r = app.contextSetRequestID(r, requestID)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) rateLimitGlobal(next http.Handler) http.Handler {
 
 	var limiter *rate.Limiter = rate.NewLimiter(2, 4)
@@ -107,9 +134,20 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r* http.Request) {
 
 		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
 
 		var authorizationHeader string = r.Header.Get("Authorization")
 
+		// Les clients machine utilisent une cle API a la place du bearer token
+		if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+			if authorizationHeader != "" {
+				app.invalidAPIKeyResponse(w, r)
+				return
+			}
+			app.authenticateAPIKey(w, r, apiKey, next)
+			return
+		}
+
 		if authorizationHeader == "" {
 			r = app.contextSetUser(r, data.AnonymousUser)
 			next.ServeHTTP(w, r)
@@ -125,6 +163,37 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		var token string = headerParts[1]
 
+		if app.cfg.auth.mode == authModeJWT && jwt.IsJWT(token) {
+			claims, err := app.jwtKeys.Verify(token)
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			user, err := app.userForClaims(claims)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound), errors.Is(err, jwt.ErrInvalidToken):
+					app.invalidAuthenticationTokenResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			if user.Locked {
+				app.lockedAccountResponse(w, r)
+				return
+			}
+
+			// Les permissions viennent du cache, pas des claims: une permission
+			// retiree ne doit pas survivre jusqu'a l'expiration du token
+			r = app.contextSetUser(r, user)
+			r = app.contextSetToken(r, token)
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		var v *validator.Validator = validator.New()
 
 		data.ValidateToken(v, token)
@@ -144,11 +213,59 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			}
 			return 
 		}
+
+		if user.Locked {
+			app.lockedAccountResponse(w, r)
+			return
+		}
+
+		// Enregistrer l'activite de la session, une erreur ici ne bloque pas la requete
+		err = app.models.Tokens.Touch(token, r.UserAgent(), app.clientIP(r))
+		if err != nil {
+			app.logError(r, err)
+		}
+
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
 		next.ServeHTTP(w, r)
 	})
 }
  
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, apiKey string, next http.Handler) {
+	key, user, err := app.models.APIKeys.GetForKey(apiKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAPIKeyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Locked {
+		app.lockedAccountResponse(w, r)
+		return
+	}
+
+	// Une cle ne peut jamais avoir plus de droits que son proprietaire
+	ownerPermissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.APIKeys.Touch(key.ID)
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetAPIKey(r, key)
+	r = app.contextSetPermissions(r, key.EffectivePermissions(*ownerPermissions))
+	next.ServeHTTP(w, r)
+}
+
 func (app *application) enableCORS(next http.Handler) http.Handler{
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Vary is to give additional cache key information
@@ -165,7 +282,7 @@ func (app *application) enableCORS(next http.Handler) http.Handler{
 			// Si la request est Preflight
 			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 				w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE" )
-				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-API-Key")
 				
 				w.Header().Set("Access-Control-Max-Age", "300")
 				w.WriteHeader(http.StatusOK)
@@ -179,13 +296,17 @@ func (app *application) enableCORS(next http.Handler) http.Handler{
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 		fn := func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
-		fmt.Println(user.ID)
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
-		fmt.Println(permissions)
-		if err != nil {
-			app.notPermittedResponse(w,r)
-			return
+
+		permissions, ok := app.contextGetPermissions(r)
+		if !ok {
+			userPermissions, err := app.models.Permissions.GetAllForUser(user.ID)
+			if err != nil {
+				app.notPermittedResponse(w,r)
+				return
+			}
+			permissions = *userPermissions
 		}
+
 		if !permissions.Include(code) {
 			app.notPermittedResponse(w, r)
 			return
@@ -209,6 +330,20 @@ func (app* application) requireActivatedUser(next http.HandlerFunc) http.Handler
 	return app.requireAuthenticatedUser(fn)
 }
 
+// Les actions sur le compte lui-meme (mot de passe, 2FA, sessions, logout, cles
+// d'API, export, suppression) demandent un utilisateur connecte, jamais une cle d'API
+func (app *application) requireInteractiveUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := app.contextGetAPIKey(r); ok {
+			app.apiKeyNotAllowedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+
+	return app.requireAuthenticatedUser(fn)
+}
+
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request ) {
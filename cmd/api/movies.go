@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
 
 	"github.com/VladimirArtyom/rest_eiga_api/internal/data"
 	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
@@ -25,6 +24,12 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		app.badRequestErrorResponse(w, r, err)
 		return
 	}
+	catalogue, err := app.models.Genres.Catalogue()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	var v *validator.Validator = validator.New()
 	var movie *data.Movie = &data.Movie{
 		Title:   inputData.Title,
@@ -32,7 +37,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		Runtime: inputData.Runtime,
 		Genres:  inputData.Genres,
 	}
-	data.ValidateMovie(v, movie)
+	data.ValidateMovie(v, movie, catalogue)
 
 	if !v.Valid() {
 		// If it has no content
@@ -40,7 +45,14 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	err = app.models.WithTx(func(tx data.Models) error {
+		err := tx.Movies.Insert(movie, app.contextGetUser(r).ID)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionCreate, "movie", movie.ID, nil, movie)
+	})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -61,6 +73,7 @@ func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request)
 	var input struct {
 		Title  string
 		Genres []string
+		Person int
 		Filter data.Filters
 	}
 
@@ -70,14 +83,16 @@ func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request)
 	input.Title = app.readString(parameters, "title", "")
 
 	input.Genres = app.readCsv(parameters, "genres", []string{})
+	input.Person = app.readInt(parameters, "person", 0, v)
 	input.Filter.Page = app.readInt(parameters, "page", 1, v)
 	input.Filter.PageSize = app.readInt(parameters, "page_size", 20, v)
 	input.Filter.Sort = app.readString(parameters, "sort", "id")
 	input.Filter.SupportedSortList = []string{
-		"id", "title", "year", "runtime",
-		"-id", "-title", "-year", "-runtime",
+		"id", "title", "year", "runtime", "average_rating", "review_count",
+		"-id", "-title", "-year", "-runtime", "-average_rating", "-review_count",
 	}
 
+	v.Check(input.Person >= 0, "person", "must be a positive integer")
 	data.ValidateFilters(v, input.Filter)
 
 	if !v.Valid() {
@@ -85,7 +100,20 @@ func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filter)
+	// Un alias filtre comme son genre canonique
+	catalogue, err := app.models.Genres.Catalogue()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for i, genre := range input.Genres {
+		if slug, ok := catalogue.Canonical(genre); ok {
+			input.Genres[i] = slug
+		}
+	}
+
+	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, int64(input.Person), input.Filter)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -142,6 +170,8 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	movie, err := app.models.Movies.Get(id)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
 		default:
@@ -151,13 +181,14 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Verifie si la demande contient un en-tete X-Expected-version.
-	if r.Header.Get("X-Expected-Version") != "" {
-		if strconv.FormatInt(int64(movie.Version), 32) != r.Header.Get("X-Expected-Version") {
-			app.editConflictResponse(w, r)
-			return
-		}
+	if !app.expectedVersionMatches(r, movie.Version) {
+		app.editConflictResponse(w, r)
+		return
 	}
 
+	before := *movie
+	before.Genres = append([]string{}, movie.Genres...)
+
 	// faire un lecteur
 	var inputData struct {
 		Title   *string       `json:"title"`
@@ -186,15 +217,28 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	if inputData.Genres != nil {
 		movie.Genres = inputData.Genres
 	}
+	catalogue, err := app.models.Genres.Catalogue()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	//validate
 	var v *validator.Validator = validator.New()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, catalogue); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Update(movie)
+	err = app.models.WithTx(func(tx data.Models) error {
+		err := tx.Movies.Update(movie, app.contextGetUser(r).ID)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionUpdate, "movie", movie.ID, before, movie)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -204,6 +248,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
+
 	fmt.Println("BERAPA KALI")
 
 	// Ecrire le fichier JSON
@@ -215,13 +260,20 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 }
 
 func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readIDParameter(r)
-	if err != nil {
-		app.notFoundResponse(w, r)
+	// L'entree garde le film tel qu'il est parti a la corbeille
+	movie, ok := app.readMovieFromIDParameter(w, r)
+	if !ok {
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	err := app.models.WithTx(func(tx data.Models) error {
+		err := tx.Movies.Delete(movie.ID)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionDelete, "movie", movie.ID, movie, nil)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/VladimirArtyom/rest_eiga_api/internal/data"
+	"github.com/VladimirArtyom/rest_eiga_api/internal/oidc"
+	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+)
+
+var errOIDCUnverifiedEmail = errors.New("the identity provider did not return a verified email address")
+
+// Redirige le navigateur vers le fournisseur d'identite (authorization code + PKCE)
+func (app *application) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var values [3]string
+	for i := range values {
+		value, err := oidc.RandomString()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		values[i] = value
+	}
+	state, nonce, codeVerifier := values[0], values[1], values[2]
+
+	err := app.models.Identities.InsertState(state, &data.OIDCState{CodeVerifier: codeVerifier, Nonce: nonce}, oidcStateTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Le state est aussi lie au navigateur, contre le login CSRF
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/v1/auth/oidc",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   app.cfg.env == "production",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, app.oidc.AuthCodeURL(state, nonce, codeVerifier), http.StatusFound)
+}
+
+// Le fournisseur d'identite renvoie ici avec un code, echange contre l'ID token
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+
+	if providerError := query.Get("error"); providerError != "" {
+		app.badRequestErrorResponse(w, r, fmt.Errorf("the identity provider returned an error: %s", providerError))
+		return
+	}
+
+	code := query.Get("code")
+	state := query.Get("state")
+
+	v := validator.New()
+	v.Check(code != "", "code", "must be provided")
+	v.Check(state != "", "state", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/v1/auth/oidc", MaxAge: -1})
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		app.invalidOIDCStateResponse(w, r)
+		return
+	}
+
+	oidcState, err := app.models.Identities.ConsumeState(state)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidOIDCStateResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	claims, err := app.oidc.Exchange(r.Context(), code, oidcState.CodeVerifier, oidcState.Nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrInvalidIDToken), errors.Is(err, oidc.ErrExchangeFailed):
+			app.logSecurityEvent(r, "oidc_login_failed", map[string]string{"error": err.Error()})
+			app.invalidOIDCLoginResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.userForOIDCClaims(r, claims)
+	if err != nil {
+		switch {
+		case errors.Is(err, errOIDCUnverifiedEmail):
+			app.errorResponse(w, r, http.StatusForbidden, err.Error())
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Locked {
+		app.lockedAccountResponse(w, r)
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+// Retrouve l'utilisateur lie a l'identite, sinon le lie (ou le cree) par son email verifie.
+// Le fournisseur a verifie l'email, le compte est donc active.
+func (app *application) userForOIDCClaims(r *http.Request, claims *oidc.Claims) (*data.User, error) {
+	issuer := app.oidc.Issuer()
+
+	user, err := app.models.Identities.GetUser(issuer, claims.Subject)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if user != nil {
+		if user.Activated {
+			return user, nil
+		}
+
+		before := *user
+		user.Activated = true
+
+		err = app.models.WithTx(func(tx data.Models) error {
+			err := tx.Users.Update(user)
+			if err != nil {
+				return err
+			}
+
+			return app.audit(tx, r, auditActionUpdate, "user", user.ID, nil, auditUserChanges(&before, user))
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return user, nil
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, claims.Email); !claims.EmailVerified || !v.Valid() {
+		return nil, errOIDCUnverifiedEmail
+	}
+
+	user, err = app.models.Users.GetByEmail(claims.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// Le compte, son identite et leurs entrees d'audit sont enregistres ensemble
+	err = app.models.WithTx(func(tx data.Models) error {
+		var err error
+		switch {
+		case user == nil:
+			user, err = app.createOIDCUser(tx, r, claims)
+		case !user.Activated:
+			// Personne n'a prouve posseder cet email avant le fournisseur: le compte a pu etre
+			// enregistre par un tiers, son mot de passe et ses tokens ne doivent pas survivre
+			err = app.resetUnactivatedUserCredentials(tx, r, user)
+		}
+		if err != nil {
+			return err
+		}
+
+		err = tx.Identities.Link(issuer, claims.Subject, user.ID)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionCreate, "identity", user.ID, nil, payload{"issuer": issuer})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Remplace le mot de passe par un mot de passe aleatoire, revoque tokens et cles d'API
+// puis active le compte, avant que l'identite ne lui soit liee.
+func (app *application) resetUnactivatedUserCredentials(tx data.Models, r *http.Request, user *data.User) error {
+	randomPassword, err := oidc.RandomString()
+	if err != nil {
+		return err
+	}
+
+	before := *user
+
+	err = user.Password.Set(randomPassword)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Tokens.DeleteAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.APIKeys.DeleteAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	user.Activated = true
+
+	err = tx.Users.Update(user)
+	if err != nil {
+		return err
+	}
+
+	err = app.audit(tx, r, auditActionUpdate, "password", user.ID, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return app.audit(tx, r, auditActionUpdate, "user", user.ID, nil, auditUserChanges(&before, user))
+}
+
+// Cree le compte et lui donne movies:read, dans la transaction de tx
+func (app *application) createOIDCUser(tx data.Models, r *http.Request, claims *oidc.Claims) (*data.User, error) {
+	name := strings.TrimSpace(claims.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+	if len(name) > 500 {
+		name = name[:500]
+	}
+
+	user := &data.User{
+		Name:      name,
+		Email:     claims.Email,
+		Activated: true,
+	}
+
+	// Le compte se connecte via le fournisseur: un mot de passe aleatoire que personne ne connait.
+	// Un reset de mot de passe permet d'en choisir un plus tard.
+	randomPassword, err := oidc.RandomString()
+	if err != nil {
+		return nil, err
+	}
+
+	err = user.Password.Set(randomPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Users.Insert(user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Permissions.AddForUser(user.ID, "movies:read")
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.audit(tx, r, auditActionCreate, "user", user.ID, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/VladimirArtyom/rest_eiga_api/internal/data"
+	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
+)
+
+func (app *application) listMovieReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieFromIDParameter(w, r)
+	if !ok {
+		return
+	}
+
+	var filters data.Filters
+
+	v := validator.New()
+	parameters := r.URL.Query()
+
+	filters.Page = app.readInt(parameters, "page", 1, v)
+	filters.PageSize = app.readInt(parameters, "page_size", 20, v)
+	filters.Sort = app.readString(parameters, "sort", "-created_at")
+	filters.SupportedSortList = []string{
+		"id", "rating", "created_at", "updated_at",
+		"-id", "-rating", "-created_at", "-updated_at",
+	}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reviews, metadata, err := app.models.Reviews.GetAllForMovie(movie.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, payload{"metadata": metadata, "reviews": reviews}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) createMovieReviewHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieFromIDParameter(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Rating int    `json:"rating"`
+		Body   string `json:"body"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestErrorResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	review := &data.Review{
+		MovieID:  movie.ID,
+		UserID:   user.ID,
+		UserName: user.Name,
+		Rating:   input.Rating,
+		Body:     input.Body,
+	}
+
+	v := validator.New()
+
+	if data.ValidateReview(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.WithTx(func(tx data.Models) error {
+		err := tx.Reviews.Insert(review)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionCreate, "review", review.ID, nil, payload{"movie_id": review.MovieID})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateReview):
+			v.AddError("movie", "you have already reviewed this movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/movies/%d/reviews/%d", movie.ID, review.ID))
+
+	err = app.writeJSON(w, payload{"review": review}, headers, http.StatusCreated)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) showMovieReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.readReviewFromParameters(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, payload{"review": review}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// Seul l'auteur peut modifier sa critique
+func (app *application) updateMovieReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.readReviewFromParameters(w, r)
+	if !ok {
+		return
+	}
+
+	if review.UserID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	before := *review
+
+	var input struct {
+		Rating *int    `json:"rating"`
+		Body   *string `json:"body"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestErrorResponse(w, r, err)
+		return
+	}
+
+	if input.Rating != nil {
+		review.Rating = *input.Rating
+	}
+
+	if input.Body != nil {
+		review.Body = *input.Body
+	}
+
+	v := validator.New()
+
+	if data.ValidateReview(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.WithTx(func(tx data.Models) error {
+		err := tx.Reviews.Update(review)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionUpdate, "review", review.ID, nil, auditChangedFields(map[string]bool{
+			"rating": before.Rating != review.Rating,
+			"body":   before.Body != review.Body,
+		}))
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, payload{"review": review}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// Seul l'auteur peut supprimer sa critique
+func (app *application) deleteMovieReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.readReviewFromParameters(w, r)
+	if !ok {
+		return
+	}
+
+	if review.UserID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err := app.models.WithTx(func(tx data.Models) error {
+		err := tx.Reviews.Delete(review.ID, review.MovieID)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionDelete, "review", review.ID, payload{"movie_id": review.MovieID}, nil)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, payload{"message": "review successfully deleted"}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// Lit le film de :id. Si ok est false, la reponse a deja ete envoyee.
+func (app *application) readMovieFromIDParameter(w http.ResponseWriter, r *http.Request) (*data.Movie, bool) {
+	id, err := app.readIDParameter(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return movie, true
+}
+
+// Lit la critique :review_id du film :id, un film a la corbeille n'a plus de critiques.
+// Si ok est false, la reponse a deja ete envoyee.
+func (app *application) readReviewFromParameters(w http.ResponseWriter, r *http.Request) (*data.Review, bool) {
+	movie, ok := app.readMovieFromIDParameter(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	reviewID, err := app.readNamedIDParameter(r, "review_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	review, err := app.models.Reviews.Get(reviewID, movie.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return review, true
+}
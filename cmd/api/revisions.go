@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+
+	"github.com/VladimirArtyom/rest_eiga_api/internal/data"
+	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
+)
+
+func (app *application) listMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieFromIDParameter(w, r)
+	if !ok {
+		return
+	}
+
+	var filters data.Filters
+
+	v := validator.New()
+	parameters := r.URL.Query()
+
+	filters.Page = app.readInt(parameters, "page", 1, v)
+	filters.PageSize = app.readInt(parameters, "page_size", 20, v)
+	filters.Sort = app.readString(parameters, "sort", "-version")
+	filters.SupportedSortList = []string{"version", "-version"}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	revisions, metadata, err := app.models.MovieRevisions.GetAllForMovie(movie.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, payload{"metadata": metadata, "revisions": revisions}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) showMovieRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieFromIDParameter(w, r)
+	if !ok {
+		return
+	}
+
+	revision, ok := app.readMovieRevisionFromParameter(w, r, movie)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, payload{"revision": revision}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// Le film reprend le contenu de la revision sous une nouvelle version
+func (app *application) revertMovieHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieFromIDParameter(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.expectedVersionMatches(r, movie.Version) {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	revision, ok := app.readMovieRevisionFromParameter(w, r, movie)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(revision.Version != movie.Version, "version", "is already the current version"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	before := *movie
+	before.Genres = append([]string{}, movie.Genres...)
+
+	movie.Title = revision.Title
+	movie.Year = revision.Year
+	movie.Runtime = revision.Runtime
+	movie.Genres = append([]string{}, revision.Genres...)
+
+	catalogue, err := app.models.Genres.Catalogue()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if data.ValidateMovie(v, movie, catalogue); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.WithTx(func(tx data.Models) error {
+		err := tx.Movies.Update(movie, app.contextGetUser(r).ID)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionRevert, "movie", movie.ID, before, movie)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, payload{"movie": movie}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// Lit la revision :version du film. Si ok est false, la reponse a deja ete envoyee.
+func (app *application) readMovieRevisionFromParameter(w http.ResponseWriter, r *http.Request, movie *data.Movie) (*data.MovieRevision, bool) {
+	version, err := app.readNamedIDParameter(r, "version")
+	if err != nil || version > math.MaxInt32 {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	revision, err := app.models.MovieRevisions.Get(movie.ID, int32(version))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return revision, true
+}
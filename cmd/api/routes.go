@@ -17,8 +17,10 @@ func (app *application) routes() http.Handler {
 	router = app.userRoutes(router)
 	router = app.userTokens(router)
 
+	router = app.adminRoutes(router)
+
 	router = app.metricRoutes(router)
-	return app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router))))
+	return app.requestID(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
 }
 
 func (app *application) movieRoutes(router *httprouter.Router) *httprouter.Router {
@@ -26,9 +28,32 @@ func (app *application) movieRoutes(router *httprouter.Router) *httprouter.Route
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.showMovieOrTrashHandler()) // et GET /v1/movies/trash
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/restore", app.requirePermission("movies:write", app.restoreMovieHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions", app.requirePermission("movies:read", app.listMovieRevisionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions/:version", app.requirePermission("movies:read", app.showMovieRevisionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/revisions/:version/revert", app.requirePermission("movies:write", app.revertMovieHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.listMovieReviewsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.createMovieReviewHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews/:review_id", app.requirePermission("movies:read", app.showMovieReviewHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id/reviews/:review_id", app.requirePermission("movies:read", app.updateMovieReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/reviews/:review_id", app.requirePermission("movies:read", app.deleteMovieReviewHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/credits", app.requirePermission("movies:read", app.listMovieCreditsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/credits", app.requirePermission("movies:write", app.createMovieCreditHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/credits/:credit_id", app.requirePermission("movies:write", app.deleteMovieCreditHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.requirePermission("movies:read", app.listGenresHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/people", app.requirePermission("movies:read", app.listPeopleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/people", app.requirePermission("movies:write", app.createPersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id", app.requirePermission("movies:read", app.showPersonHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/people/:id", app.requirePermission("movies:write", app.updatePersonHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/people/:id", app.requirePermission("movies:write", app.deletePersonHandler))
 
 	return router
 }
@@ -37,12 +62,69 @@ func (app *application) userRoutes(router *httprouter.Router) *httprouter.Router
 	
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/unlocked", app.unlockUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireAuthenticatedUser(app.showCurrentUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireInteractiveUser(app.updateCurrentUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me", app.requireInteractiveUser(app.deleteCurrentUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/export", app.requireInteractiveUser(app.exportCurrentUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/password", app.requireInteractiveUser(app.changeCurrentUserPasswordHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/sessions", app.requireInteractiveUser(app.listUserSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions/:id", app.requireInteractiveUser(app.deleteUserSessionHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/2fa", app.requirePermission("movies:write", app.requireInteractiveUser(app.enableTwoFactorHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/2fa/confirm", app.requirePermission("movies:write", app.requireInteractiveUser(app.confirmTwoFactorHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/2fa", app.requireActivatedUser(app.requireInteractiveUser(app.disableTwoFactorHandler)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/api-keys", app.requireActivatedUser(app.requireInteractiveUser(app.listUserAPIKeysHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/api-keys", app.requireActivatedUser(app.requireInteractiveUser(app.createUserAPIKeyHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/api-keys/:id", app.requireActivatedUser(app.requireInteractiveUser(app.deleteUserAPIKeyHandler)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/watchlist", app.requirePermission("movies:read", app.listWatchlistHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/watchlist", app.requirePermission("movies:read", app.addToWatchlistHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/watchlist/:id", app.requirePermission("movies:read", app.removeFromWatchlistHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/watched", app.requirePermission("movies:read", app.listWatchedHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/watched", app.requirePermission("movies:read", app.addWatchedHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/watched/:id", app.requirePermission("movies:read", app.deleteWatchedHandler))
 
 	return router
 }
 
 func (app *application) userTokens(router *httprouter.Router) *httprouter.Router {
 	router.HandlerFunc(http.MethodPost,"/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/2fa", app.createTwoFactorAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireInteractiveUser(app.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/all", app.requireInteractiveUser(app.deleteAllAuthenticationTokensHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/auth/oidc/login", app.oidcLoginHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/auth/oidc/callback", app.oidcCallbackHandler)
+	return router
+}
+
+func (app *application) adminRoutes(router *httprouter.Router) *httprouter.Router {
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requirePermission("users:admin", app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requirePermission("users:admin", app.showUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/permissions", app.requirePermission("users:admin", app.listUserPermissionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/permissions", app.requirePermission("users:admin", app.grantUserPermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions/:code", app.requirePermission("users:admin", app.revokeUserPermissionHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/activated", app.requirePermission("users:admin", app.updateUserActivationHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/locked", app.requirePermission("users:admin", app.updateUserLockHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/roles", app.requirePermission("roles:admin", app.listRolesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/roles", app.requirePermission("roles:admin", app.createRoleHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/roles", app.requirePermission("roles:admin", app.listUserRolesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/roles", app.requirePermission("roles:admin", app.assignUserRoleHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/roles/:role", app.requirePermission("roles:admin", app.removeUserRoleHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/audit", app.requirePermission("users:admin", app.listAuditHandler))
+
 	return router
 }
 
@@ -22,6 +22,7 @@ func (app *application) serve() error {
 		WriteTimeout: 30 * time.Second,
 	}
 	shutdownErrorChannel := make(chan error)
+	stopPurge := make(chan struct{})
 
 	go func() {
 		quit := make(chan os.Signal, 1)
@@ -47,6 +48,7 @@ func (app *application) serve() error {
 			"addr": server.Addr,
 		})	
 
+		close(stopPurge)
 		app.wg.Wait()
 		shutdownErrorChannel <- nil
 	}()
@@ -56,6 +58,8 @@ func (app *application) serve() error {
 		"env":  app.cfg.env,
 	})
 
+	app.purgeTrashedMovies(stopPurge)
+
 	err := server.ListenAndServe()
 	
 	if !errors.Is(err, http.ErrServerClosed) {
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/VladimirArtyom/rest_eiga_api/internal/data"
+	"github.com/VladimirArtyom/rest_eiga_api/internal/jwt"
+)
+
+func (app *application) listUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	family, err := app.currentSessionFamily(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sessions, err := app.models.Tokens.GetAllSessionsForUser(user.ID, family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, payload{"sessions": sessions}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) deleteUserSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParameter(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.WithTx(func(tx data.Models) error {
+		err := tx.Tokens.DeleteSessionForUser(id, user.ID)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionDelete, "session", id, nil, nil)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, payload{"message": "session successfully revoked"}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// La famille du token de la requete, pour marquer la session courante.
+// nil si le token n'appartient a aucune famille.
+func (app *application) currentSessionFamily(r *http.Request) ([]byte, error) {
+	token := app.contextGetToken(r)
+
+	// Un JWT porte sa famille, il n'a pas de ligne dans tokens
+	if app.cfg.auth.mode == authModeJWT && jwt.IsJWT(token) {
+		claims, err := app.jwtKeys.Verify(token)
+		if err != nil {
+			return nil, nil
+		}
+
+		family, err := familyFromClaims(claims)
+		if err != nil || len(family) == 0 {
+			return nil, nil
+		}
+
+		return family, nil
+	}
+
+	family, err := app.models.Tokens.GetFamily(data.ScopeAuthentication, token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	return family, nil
+}
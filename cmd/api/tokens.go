@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/VladimirArtyom/rest_eiga_api/internal/data"
+	"github.com/VladimirArtyom/rest_eiga_api/internal/jwt"
 	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
 )
 
@@ -34,10 +35,20 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	// Verrouillage et delais progressifs par compte
+	if !app.checkLoginThrottle(w, r, input.Email) {
+		return
+	}
+
 	user, err := app.models.Users.GetByEmail(input.Email)
 	if err != nil {
 		switch {
 			case errors.Is(err, data.ErrRecordNotFound):
+				err = app.recordLoginFailure(r, input.Email, nil, "unknown email")
+				if err != nil {
+					app.serverErrorResponse(w, r, err)
+					return
+				}
 				app.invalidCredentialResponse(w,r)
 			default:
 				app.serverErrorResponse(w, r, err)
@@ -55,22 +66,429 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	}
 	
 	if !isMatched {
+		err = app.recordLoginFailure(r, input.Email, user, "invalid password")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 		app.invalidCredentialResponse(w, r)
 		return
 	}
 
-	// We generate a token for 1 day expiry time
-	token, err := app.models.Tokens.New(user.ID, 1*24*time.Hour, data.ScopeAuthentication)
+	// Un hash d'un ancien algorithme (ou d'un ancien cout) est mis a jour
+	// pendant que l'on a le mot de passe en clair. Un echec ne bloque pas le login.
+	if user.Password.Outdated() {
+		err = app.models.Users.RehashPassword(user, input.Password)
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+
+	err = app.models.LoginAttempts.Clear(input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user.Locked {
+		app.lockedAccountResponse(w, r)
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	type email_data struct {
+		PasswordResetToken string
+		Email string
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("email", "no matching email address found")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !user.Activated {
+		v.AddError("email", "user account must be activated")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Le token de reinitialisation est valable 45 minutes seulement
+	var token *data.Token
+	err = app.models.WithTx(func(tx data.Models) error {
+		var err error
+		token, err = tx.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionCreate, "password_reset_token", user.ID, nil, nil)
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	out := &email_data{
+		PasswordResetToken: token.Plaintext,
+		Email: user.Email,
+	}
+
+	app.background(func(v interface{}) {
+		user, _ := v.(email_data)
+		err := app.mailer.Send(user.Email, "token_password_reset.tmpl", user)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	}, *out)
+
+	err = app.writeJSON(w, payload{"message": "an email will be sent to you containing password reset instructions"}, nil, http.StatusAccepted)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	type email_data struct {
+		ActivationToken string
+		Email string
+		ID int64
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("email", "no matching email address found")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Activated {
+		v.AddError("email", "user has already been activated")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var token *data.Token
+	err = app.models.WithTx(func(tx data.Models) error {
+		// Les anciens tokens d'activation ne doivent plus etre utilisables
+		err := tx.Tokens.DeleteAllTokensForUser(data.ScopeActivation, user.ID)
+		if err != nil {
+			return err
+		}
+
+		token, err = tx.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionCreate, "activation_token", user.ID, nil, nil)
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	out := &email_data{
+		ActivationToken: token.Plaintext,
+		Email: user.Email,
+		ID: user.ID,
+	}
+
+	app.background(func(v interface{}) {
+		user, _ := v.(email_data)
+		err := app.mailer.Send(user.Email, "token_activation.tmpl", user)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	}, *out)
+
+	err = app.writeJSON(w, payload{"message": "an email will be sent to you containing activation instructions"}, nil, http.StatusAccepted)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// Logout: revoque le bearer token utilise pour cette requete et son refresh token
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	token := app.contextGetToken(r)
+
+	err := app.models.WithTx(func(tx data.Models) error {
+		var err error
+		if app.cfg.auth.mode == authModeJWT && jwt.IsJWT(token) {
+			// Un JWT ne peut pas etre revoque, il expire tout seul.
+			// On revoque le refresh token emis avec lui.
+			err = app.revokeJWTFamily(tx, token)
+		} else {
+			err = tx.Tokens.Delete(data.ScopeAuthentication, token)
+		}
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionLogout, "user", app.contextGetUser(r).ID, nil, nil)
+	})
+	if err != nil {
+		switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, payload{"message": "authentication token successfully revoked"}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// Revoque toutes les sessions de l'utilisateur courant
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.WithTx(func(tx data.Models) error {
+		for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh} {
+			err := tx.Tokens.DeleteAllTokensForUser(scope, user.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return app.audit(tx, r, auditActionLogout, "user", user.ID, nil, payload{"all_sessions": true})
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, payload{"message": "all authentication tokens successfully revoked"}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// Echange un refresh token contre une nouvelle paire de tokens (rotation)
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateToken(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	consumed, err := app.models.Tokens.Rotate(input.RefreshToken)
+	if err != nil {
+		switch {
+			case errors.Is(err, data.ErrTokenReused):
+				// Quelqu'un rejoue un ancien refresh token, toute la famille est revoquee
+				app.logger.PrintInfo("refresh token reuse detected, token family revoked", map[string]string{
+					"ip": app.clientIP(r),
+				})
+				app.invalidRefreshTokenResponse(w, r)
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidRefreshTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Users.Get(consumed.UserID)
+	if err != nil {
+		switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidRefreshTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Locked {
+		app.lockedAccountResponse(w, r)
+		return
+	}
+
+	// La rotation reste hors de la transaction: une famille revoquee pour reutilisation doit le rester
+	var token, refreshToken *data.Token
+	err = app.models.WithTx(func(tx data.Models) error {
+		var err error
+		token, refreshToken, err = app.newAuthenticationTokens(tx, user, consumed.Family)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionCreate, "token_refresh", user.ID, nil, nil)
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, payload{"authentication_token": token, "refresh_token": refreshToken}, nil, http.StatusOK)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+}
 
-	err = app.writeJSON(w, payload{"authentication_token": token}, nil, http.StatusOK)
+// Termine une connexion reussie: challenge 2FA ou tokens d'authentification
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *data.User) {
+	// Avec la 2FA, le mot de passe (ou le fournisseur d'identite) ne suffit pas: on renvoie un challenge
+	twoFactorEnabled, err := app.models.TwoFactors.IsEnabled(user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	if twoFactorEnabled {
+		challenge, err := app.models.Tokens.New(user.ID, 5*time.Minute, data.ScopeTwoFactorChallenge)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, payload{"two_factor_required": true, "challenge_token": challenge}, nil, http.StatusAccepted)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var token, refreshToken *data.Token
+	err = app.models.WithTx(func(tx data.Models) error {
+		var err error
+
+		// A short-lived access token, and a refresh token to get a new one
+		token, refreshToken, err = app.newAuthenticationTokens(tx, user, nil)
+		if err != nil {
+			return err
+		}
+
+		// Le premier refresh token garde le client du login, pour la liste des sessions
+		err = tx.Tokens.Touch(refreshToken.Plaintext, r.UserAgent(), app.clientIP(r))
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionLogin, "user", user.ID, nil, nil)
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, payload{"authentication_token": token, "refresh_token": refreshToken}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// Emet un token d'authentification et un refresh token, selon le mode configure.
+// Une famille nil commence une nouvelle session.
+func (app *application) newAuthenticationTokens(models data.Models, user *data.User, family []byte) (*data.Token, *data.Token, error) {
+	if app.cfg.auth.mode != authModeJWT {
+		return models.Tokens.NewPair(user.ID, app.cfg.tokens.authenticationTTL, app.cfg.tokens.refreshTTL, family)
+	}
+
+	if family == nil {
+		var err error
+		family, err = data.NewTokenFamily()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	token, err := app.newAuthenticationJWT(user, family)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refreshToken, err := models.Tokens.NewInFamily(user.ID, app.cfg.tokens.refreshTTL, data.ScopeRefresh, family)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return token, refreshToken, nil
+}
+
+func (app *application) revokeJWTFamily(models data.Models, token string) error {
+	claims, err := app.jwtKeys.Verify(token)
+	if err != nil {
+		return data.ErrRecordNotFound
+	}
+
+	family, err := familyFromClaims(claims)
+	if err != nil || len(family) == 0 {
+		return data.ErrRecordNotFound
+	}
 
+	return models.Tokens.DeleteFamily(family)
 }
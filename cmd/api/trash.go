@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/VladimirArtyom/rest_eiga_api/internal/data"
+	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
+)
+
+// httprouter ne permet pas /v1/movies/trash a cote de /v1/movies/:id, on aiguille ici
+func (app *application) showMovieOrTrashHandler() http.HandlerFunc {
+	showMovie := app.requirePermission("movies:read", app.showMovieHandler)
+	listTrash := app.requirePermission("movies:write", app.listTrashedMoviesHandler)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.readParameter(r, "id") == "trash" {
+			listTrash(w, r)
+			return
+		}
+
+		showMovie(w, r)
+	}
+}
+
+func (app *application) listTrashedMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+
+	v := validator.New()
+	parameters := r.URL.Query()
+
+	filters.Page = app.readInt(parameters, "page", 1, v)
+	filters.PageSize = app.readInt(parameters, "page_size", 20, v)
+	filters.Sort = app.readString(parameters, "sort", "-deleted_at")
+	filters.SupportedSortList = []string{
+		"id", "title", "deleted_at",
+		"-id", "-title", "-deleted_at",
+	}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetAllTrashed(filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, payload{"metadata": metadata, "movies": movies}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParameter(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var movie *data.Movie
+
+	err = app.models.WithTx(func(tx data.Models) error {
+		err := tx.Movies.Restore(id)
+		if err != nil {
+			return err
+		}
+
+		movie, err = tx.Movies.Get(id)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionRestore, "movie", id, nil, movie)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, payload{"movie": movie}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// Purge la corbeille toutes les trash.purgeInterval jusqu'a la fermeture de stop
+func (app *application) purgeTrashedMovies(stop <-chan struct{}) {
+	if app.cfg.trash.retention <= 0 {
+		return
+	}
+
+	app.background(func(params interface{}) {
+		ticker := time.NewTicker(app.cfg.trash.purgeInterval)
+		defer ticker.Stop()
+
+		for {
+			purged, err := app.models.Movies.Purge(time.Now().Add(-app.cfg.trash.retention))
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			} else if purged > 0 {
+				app.logger.PrintInfo("trashed movies purged", map[string]string{
+					"count": fmt.Sprint(purged),
+				})
+			}
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}, nil)
+}
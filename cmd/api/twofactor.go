@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/VladimirArtyom/rest_eiga_api/internal/data"
+	"github.com/VladimirArtyom/rest_eiga_api/internal/totp"
+	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
+)
+
+// Une periode de decalage d'horloge est toleree de chaque cote
+const totpSkew = 1
+
+func (app *application) enableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var recoveryCodes []string
+	err = app.models.WithTx(func(tx data.Models) error {
+		var err error
+		recoveryCodes, err = tx.TwoFactors.Enroll(user.ID, secret)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionCreate, "two_factor", user.ID, nil, payload{"confirmed": false})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTwoFactorAlreadyEnabled):
+			v := validator.New()
+			v.AddError("two_factor", "two-factor authentication is already enabled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Les codes de recuperation ne sont montres qu'une seule fois
+	err = app.writeJSON(w, payload{
+		"otpauth_uri":    totp.URI(app.cfg.totp.issuer, user.Email, secret),
+		"secret":         totp.EncodeSecret(secret),
+		"recovery_codes": recoveryCodes,
+	}, nil, http.StatusCreated)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	twoFactor, err := app.models.TwoFactors.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("two_factor", "two-factor authentication enrollment has not been started")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if twoFactor.Confirmed {
+		v.AddError("two_factor", "two-factor authentication is already enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var isValid bool
+	err = app.models.WithTx(func(tx data.Models) error {
+		var err error
+		isValid, err = app.verifyTOTPCode(tx, twoFactor, input.Code)
+		if err != nil || !isValid {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionUpdate, "two_factor", user.ID, payload{"confirmed": false}, payload{"confirmed": true})
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !isValid {
+		app.invalidTOTPCodeResponse(w, r)
+		return
+	}
+
+	err = app.writeJSON(w, payload{"message": "two-factor authentication successfully enabled"}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidatePasswordPlainText(v, input.Password); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// L'utilisateur du contexte peut venir d'un JWT, sans mot de passe
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !app.verifyCurrentPassword(w, r, user, input.Password) {
+		return
+	}
+
+	err = app.models.WithTx(func(tx data.Models) error {
+		err := tx.TwoFactors.Disable(user.ID)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionDelete, "two_factor", user.ID, nil, nil)
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, payload{"message": "two-factor authentication successfully disabled"}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// Deuxieme etape du login: le challenge token et un code TOTP (ou de recuperation)
+// sont echanges contre les tokens d'authentification
+func (app *application) createTwoFactorAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.ChallengeToken != "", "challenge_token", "must be provided")
+	v.Check(input.Code != "" || input.RecoveryCode != "", "code", "a code or a recovery code must be provided")
+	if input.Code != "" {
+		data.ValidateTOTPCode(v, input.Code)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeTwoFactorChallenge, input.ChallengeToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("challenge_token", "invalid or expired challenge token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Locked {
+		app.lockedAccountResponse(w, r)
+		return
+	}
+
+	// Les codes a 6 chiffres sont proteges comme les mots de passe
+	if !app.checkLoginThrottle(w, r, user.Email) {
+		return
+	}
+
+	if input.RecoveryCode != "" {
+		err = app.models.TwoFactors.UseRecoveryCode(user.ID, input.RecoveryCode)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				err = app.recordLoginFailure(r, user.Email, user, "invalid recovery code")
+				if err != nil {
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+				app.invalidCredentialResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		app.logSecurityEvent(r, "auth.recovery_code_used", map[string]string{
+			"email": user.Email,
+			"ip":    app.clientIP(r),
+		})
+	} else {
+		twoFactor, err := app.models.TwoFactors.Get(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		isValid, err := app.verifyTOTPCode(app.models, twoFactor, input.Code)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !isValid {
+			err = app.recordLoginFailure(r, user.Email, user, "invalid totp code")
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			app.invalidTOTPCodeResponse(w, r)
+			return
+		}
+	}
+
+	var token, refreshToken *data.Token
+	err = app.models.WithTx(func(tx data.Models) error {
+		err := tx.Tokens.DeleteAllTokensForUser(data.ScopeTwoFactorChallenge, user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.LoginAttempts.Clear(user.Email)
+		if err != nil {
+			return err
+		}
+
+		token, refreshToken, err = app.newAuthenticationTokens(tx, user, nil)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionLogin, "user", user.ID, nil, nil)
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, payload{"authentication_token": token, "refresh_token": refreshToken}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// Verifie un code TOTP et le marque comme utilise: un code deja utilise est refuse
+func (app *application) verifyTOTPCode(models data.Models, twoFactor *data.TwoFactor, code string) (bool, error) {
+	step, valid := totp.Validate(twoFactor.Secret, code, time.Now(), totpSkew)
+	if !valid {
+		return false, nil
+	}
+
+	err := models.TwoFactors.UseStep(twoFactor.UserID, step)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTOTPCodeReused):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (app *application) invalidTOTPCodeResponse(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	v.AddError("code", "invalid or already used code")
+	app.failedValidationResponse(w, r, v.Errors)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/VladimirArtyom/rest_eiga_api/internal/data"
@@ -46,6 +47,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	// Valider les données
 	v := validator.New()
 	data.ValidateUser(v, user)
+	app.passwordPolicy.Validate(v, inputData.Password, user)
 
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
@@ -53,8 +55,25 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	// データをデータベースに保存する
-	err = app.models.Users.Insert(user)
-	
+	var token *data.Token
+	err = app.models.WithTx(func(tx data.Models) error {
+		err := tx.Users.Insert(user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Permissions.AddForUser(user.ID, "movies:read")
+		if err != nil {
+			return err
+		}
+
+		token, err = tx.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionCreate, "user", user.ID, nil, nil)
+	})
 	if err != nil {
 		switch {
 			case errors.Is(err, data.ErrDuplicateEmail):
@@ -66,17 +85,6 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 			return
 	}
 
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return 
-	}
-	
-	token , err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
 	// メールを送信する
 		out := &email_data {
 				Email: user.Email,
@@ -135,10 +143,25 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	before := *user
+
 	user.Activated = true
 	
 	// Mise a jour un nouveau utilisateur
-	err = app.models.Users.Update(user)
+	err = app.models.WithTx(func(tx data.Models) error {
+		err := tx.Users.Update(user)
+		if err != nil {
+			return err
+		}
+
+		// Supprimer tous les tokens utilisateur
+		err = tx.Tokens.DeleteAllTokensForUser(data.ScopeActivation, user.ID)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionUpdate, "user", user.ID, nil, auditUserChanges(&before, user))
+	})
 	if err != nil {
 			switch {
 				case errors.Is(err, data.ErrEditConflict):
@@ -149,18 +172,433 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Supprimer tous les tokens utilisateur
-	err = app.models.Tokens.DeleteAllTokensForUser(data.ScopeActivation, user.ID)
+	//Envoyer l'utilisateur mis a jour au client dans une response JSON
+	err = app.writeJSON(w, payload{"user": user}, nil, http.StatusOK )
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	//Envoyer l'utilisateur mis a jour au client dans une response JSON
-	err = app.writeJSON(w, payload{"user": user}, nil, http.StatusOK )
+}
+
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlainText string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestErrorResponse(w, r, err)
+		return
+	}
+
+	var v *validator.Validator = validator.New()
+
+	data.ValidatePasswordPlainText(v, input.Password)
+	data.ValidateToken(v, input.TokenPlainText)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlainText)
+	if err != nil {
+		switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("token", "invalid or expired password reset token")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if app.passwordPolicy.Validate(v, input.Password, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Update() incremente aussi la version de l'utilisateur
+	err = app.models.WithTx(func(tx data.Models) error {
+		err := tx.Users.Update(user)
+		if err != nil {
+			return err
+		}
+
+		// Revoquer toutes les sessions et tous les tokens existants
+		err = tx.Tokens.DeleteAllForUser(user.ID)
+		if err != nil {
+			return err
+		}
+
+		// Le nouveau mot de passe leve aussi un verrouillage temporaire
+		err = tx.LoginAttempts.Clear(user.Email)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionUpdate, "password", user.ID, nil, nil)
+	})
+	if err != nil {
+		switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, payload{"message": "your password was successfully reset"}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// Met a jour le profil de l'utilisateur courant. Le nom change tout de suite,
+// une nouvelle adresse email doit d'abord etre confirmee par son proprietaire.
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		Name  *string `json:"name"`
+		Email *string `json:"email"`
+	}
+
+	type email_data struct {
+		EmailChangeToken string
+		Email            string
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestErrorResponse(w, r, err)
+		return
+	}
+
+	// Le user du contexte peut venir d'un JWT, on relit la version en base
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	before := *user
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	// citext: changer seulement la casse n'est pas un changement d'adresse
+	emailChange := input.Email != nil && !strings.EqualFold(*input.Email, user.Email)
+
+	v := validator.New()
+	data.ValidateUser(v, user)
+
+	if emailChange {
+		data.ValidateEmail(v, *input.Email)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if emailChange {
+		_, err = app.models.Users.GetByEmail(*input.Email)
+		switch {
+			case err == nil:
+				v.AddError("email", "a user with this email address already exists")
+				app.failedValidationResponse(w, r, v.Errors)
+				return
+			case !errors.Is(err, data.ErrRecordNotFound):
+				app.serverErrorResponse(w, r, err)
+				return
+		}
+	}
+
+	if input.Name != nil {
+		err = app.models.WithTx(func(tx data.Models) error {
+			err := tx.Users.Update(user)
+			if err != nil {
+				return err
+			}
+
+			return app.audit(tx, r, auditActionUpdate, "user", user.ID, nil, auditUserChanges(&before, user))
+		})
+		if err != nil {
+			switch {
+				case errors.Is(err, data.ErrEditConflict):
+					app.editConflictResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	if !emailChange {
+		err = app.writeJSON(w, payload{"user": user}, nil, http.StatusOK)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var token *data.Token
+	err = app.models.WithTx(func(tx data.Models) error {
+		err := tx.Users.SetPendingEmail(user.ID, *input.Email)
+		if err != nil {
+			return err
+		}
+
+		// Seule la derniere demande de changement reste valable
+		err = tx.Tokens.DeleteAllTokensForUser(data.ScopeEmailChange, user.ID)
+		if err != nil {
+			return err
+		}
+
+		token, err = tx.Tokens.New(user.ID, 24*time.Hour, data.ScopeEmailChange)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionCreate, "email_change", user.ID, nil, nil)
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	out := &email_data{
+		EmailChangeToken: token.Plaintext,
+		Email:            *input.Email,
+	}
+
+	app.background(func(v interface{}) {
+		user, _ := v.(email_data)
+		err := app.mailer.Send(user.Email, "email_change.tmpl", user)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	}, *out)
+
+	err = app.writeJSON(w, payload{
+		"user":    user,
+		"message": "an email will be sent to your new address containing confirmation instructions",
+	}, nil, http.StatusAccepted)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// Confirme la nouvelle adresse email avec le token envoye a cette adresse
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		TokenPlainText string `json:"token"`
+	}
+
+	type email_data struct {
+		Email    string
+		NewEmail string
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestErrorResponse(w, r, err)
+		return
+	}
+
+	var v *validator.Validator = validator.New()
+
+	if data.ValidateToken(v, input.TokenPlainText); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeEmailChange, input.TokenPlainText)
+	if err != nil {
+		switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("token", "invalid or expired email change token")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	oldEmail := user.Email
+
+	err = app.models.WithTx(func(tx data.Models) error {
+		err := tx.Users.CommitPendingEmail(user)
+		if err != nil {
+			return err
+		}
+
+		// Les liens deja envoyes a l'ancienne adresse ne servent plus
+		for _, scope := range []string{data.ScopeEmailChange, data.ScopePasswordReset} {
+			err = tx.Tokens.DeleteAllTokensForUser(scope, user.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return app.audit(tx, r, auditActionUpdate, "user", user.ID, nil, auditChangedFields(map[string]bool{"email": true}))
+	})
+	if err != nil {
+		switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("token", "invalid or expired email change token")
+				app.failedValidationResponse(w, r, v.Errors)
+			case errors.Is(err, data.ErrDuplicateEmail):
+				v.AddError("email", "a user with this email address already exists")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Prevenir l'ancienne adresse, au cas ou ce ne serait pas le proprietaire
+	out := &email_data{
+		Email:    oldEmail,
+		NewEmail: user.Email,
+	}
+
+	app.background(func(v interface{}) {
+		user, _ := v.(email_data)
+		err := app.mailer.Send(user.Email, "email_changed.tmpl", user)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	}, *out)
+
+	err = app.writeJSON(w, payload{"user": user}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// "Qui suis-je": l'utilisateur authentifie, avec ses permissions
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Relu en base: un JWT peut avoir ete emis avant un changement
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, payload{"user": user, "permissions": permissions}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// Change le mot de passe de l'utilisateur courant, l'ancien doit etre fourni.
+// Les autres sessions sont revoquees et une nouvelle paire de tokens est renvoyee.
+func (app *application) changeCurrentUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestErrorResponse(w, r, err)
+		return
+	}
+
+	var v *validator.Validator = validator.New()
+
+	v.Check(input.CurrentPassword != "", "current_password", "must be provided")
+	data.ValidatePasswordPlainText(v, input.Password)
+	v.Check(input.Password != input.CurrentPassword, "password", "must be different from the current password")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// L'utilisateur du contexte peut venir d'un JWT, sans mot de passe
+	user, err := app.models.Users.Get(app.contextGetUser(r).ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	if !app.verifyCurrentPassword(w, r, user, input.CurrentPassword) {
+		return
+	}
+
+	if app.passwordPolicy.Validate(v, input.Password, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var token, refreshToken *data.Token
+	err = app.models.WithTx(func(tx data.Models) error {
+		err := tx.Users.Update(user)
+		if err != nil {
+			return err
+		}
+
+		for _, scope := range []string{data.ScopeAuthentication, data.ScopeRefresh, data.ScopePasswordReset} {
+			err = tx.Tokens.DeleteAllTokensForUser(scope, user.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		token, refreshToken, err = app.newAuthenticationTokens(tx, user, nil)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionUpdate, "password", user.ID, nil, nil)
+	})
+	if err != nil {
+		switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, payload{
+		"message":              "your password was successfully changed",
+		"authentication_token": token,
+		"refresh_token":        refreshToken,
+	}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 }
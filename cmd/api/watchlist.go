@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/VladimirArtyom/rest_eiga_api/internal/data"
+	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
+)
+
+func (app *application) listWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+
+	v := validator.New()
+	parameters := r.URL.Query()
+
+	filters.Page = app.readInt(parameters, "page", 1, v)
+	filters.PageSize = app.readInt(parameters, "page_size", 20, v)
+	filters.Sort = app.readString(parameters, "sort", "-added_at")
+	filters.SupportedSortList = []string{
+		"added_at", "title", "year",
+		"-added_at", "-title", "-year",
+	}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.models.Watchlist.GetAllForUser(app.contextGetUser(r).ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, payload{"metadata": metadata, "watchlist": entries}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) addToWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MovieID int64 `json:"movie_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.MovieID > 0, "movie_id", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var entry *data.WatchlistEntry
+	err = app.models.WithTx(func(tx data.Models) error {
+		var err error
+		entry, err = tx.Watchlist.Add(app.contextGetUser(r).ID, input.MovieID)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionCreate, "watchlist", entry.MovieID, nil, entry)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateWatchlistEntry):
+			v.AddError("movie_id", "this movie is already in your watchlist")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("movie_id", "this movie does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, payload{"entry": entry}, nil, http.StatusCreated)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// :id est l'identifiant du film
+func (app *application) removeFromWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParameter(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.WithTx(func(tx data.Models) error {
+		err := tx.Watchlist.Remove(app.contextGetUser(r).ID, movieID)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionDelete, "watchlist", movieID, nil, nil)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, payload{"message": "movie successfully removed from the watchlist"}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) listWatchedHandler(w http.ResponseWriter, r *http.Request) {
+	var filters data.Filters
+
+	v := validator.New()
+	parameters := r.URL.Query()
+
+	filters.Page = app.readInt(parameters, "page", 1, v)
+	filters.PageSize = app.readInt(parameters, "page_size", 20, v)
+	filters.Sort = app.readString(parameters, "sort", "-watched_on")
+	filters.SupportedSortList = []string{
+		"watched_on", "title", "rating",
+		"-watched_on", "-title", "-rating",
+	}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.models.Watched.GetAllForUser(app.contextGetUser(r).ID, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, payload{"metadata": metadata, "watched": entries}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+func (app *application) addWatchedHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MovieID   int64  `json:"movie_id"`
+		WatchedOn string `json:"watched_on"` // 2006-01-02, aujourd'hui par defaut
+		Rating    *int   `json:"rating"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	entry := &data.WatchedEntry{
+		MovieID:   input.MovieID,
+		WatchedOn: time.Now().UTC().Truncate(24 * time.Hour),
+		Rating:    input.Rating,
+	}
+
+	if input.WatchedOn != "" {
+		entry.WatchedOn, err = time.Parse("2006-01-02", input.WatchedOn)
+		v.Check(err == nil, "watched_on", "must be a date like 2006-01-02")
+	}
+
+	if data.ValidateWatchedEntry(v, entry); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.WithTx(func(tx data.Models) error {
+		err := tx.Watched.Insert(app.contextGetUser(r).ID, entry)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionCreate, "watched", entry.ID, nil, entry)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("movie_id", "this movie does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, payload{"entry": entry}, nil, http.StatusCreated)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// :id est l'identifiant de l'entree de l'historique
+func (app *application) deleteWatchedHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParameter(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.WithTx(func(tx data.Models) error {
+		err := tx.Watched.DeleteForUser(id, app.contextGetUser(r).ID)
+		if err != nil {
+			return err
+		}
+
+		return app.audit(tx, r, auditActionDelete, "watched", id, nil, nil)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, payload{"message": "entry successfully removed from the watched history"}, nil, http.StatusOK)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
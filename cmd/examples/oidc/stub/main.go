@@ -0,0 +1,226 @@
+// A minimal OpenID Connect issuer, to try /v1/auth/oidc/login locally.
+// It approves every authorization request for a single configured account.
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/VladimirArtyom/rest_eiga_api/internal/oidc"
+)
+
+const keyID = "stub"
+
+var encoding = base64.RawURLEncoding
+
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+}
+
+type stub struct {
+	issuer        string
+	clientID      string
+	subject       string
+	email         string
+	name          string
+	emailVerified bool
+
+	key *rsa.PrivateKey
+
+	mutex sync.Mutex
+	codes map[string]authorization
+}
+
+func main() {
+	addr := flag.String("addr", ":9999", "Server address")
+
+	s := &stub{codes: make(map[string]authorization)}
+	flag.StringVar(&s.issuer, "issuer", "http://localhost:9999", "The issuer URL, as configured with -oidc-issuer")
+	flag.StringVar(&s.clientID, "client-id", "eiga", "The only accepted client ID")
+	flag.StringVar(&s.subject, "sub", "stub-user-1", "The subject of the logged in account")
+	flag.StringVar(&s.email, "email", "stub@memes.com", "The email of the logged in account")
+	flag.StringVar(&s.name, "name", "Stub User", "The name of the logged in account")
+	flag.BoolVar(&s.emailVerified, "email-verified", true, "Whether the email is reported as verified")
+	flag.Parse()
+
+	var err error
+	s.key, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("starting stub issuer %s on %s", s.issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, s.routes()))
+}
+
+func (s *stub) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	mux.HandleFunc("/jwks", s.jwks)
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func (s *stub) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.issuer,
+		"authorization_endpoint":                s.issuer + "/authorize",
+		"token_endpoint":                        s.issuer + "/token",
+		"jwks_uri":                              s.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// Pas de page de consentement: le code est emis directement
+func (s *stub) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if query.Get("client_id") != s.clientID || query.Get("response_type") != "code" ||
+		query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code, err := oidc.RandomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.mutex.Lock()
+	s.codes[code] = authorization{
+		clientID:      s.clientID,
+		redirectURI:   redirectURI.String(),
+		codeChallenge: query.Get("code_challenge"),
+		nonce:         query.Get("nonce"),
+	}
+	s.mutex.Unlock()
+
+	values := redirectURI.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	redirectURI.RawQuery = values.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (s *stub) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	// Un code ne sert qu'une fois
+	code := r.PostForm.Get("code")
+	s.mutex.Lock()
+	auth, found := s.codes[code]
+	delete(s.codes, code)
+	s.mutex.Unlock()
+
+	clientID := r.PostForm.Get("client_id")
+	if username, _, ok := r.BasicAuth(); ok {
+		clientID, _ = url.QueryUnescape(username)
+	}
+
+	if !found || r.PostForm.Get("grant_type") != "authorization_code" ||
+		clientID != auth.clientID || r.PostForm.Get("redirect_uri") != auth.redirectURI ||
+		oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != auth.codeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	idToken, err := s.sign(map[string]interface{}{
+		"iss":            s.issuer,
+		"sub":            s.subject,
+		"aud":            auth.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          auth.nonce,
+		"email":          s.email,
+		"email_verified": s.emailVerified,
+		"name":           s.name,
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": code,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (s *stub) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   encoding.EncodeToString(s.key.N.Bytes()),
+			"e":   encoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (s *stub) sign(claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	if err != nil {
+		return "", err
+	}
+
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encoding.EncodeToString(header) + "." + encoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + encoding.EncodeToString(signature), nil
+}
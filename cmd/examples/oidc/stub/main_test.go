@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VladimirArtyom/rest_eiga_api/internal/oidc"
+)
+
+// Demarre le stub et compte les requetes sur /jwks
+func startStub(t *testing.T) (*stub, *oidc.Provider, *atomic.Int64) {
+	t.Helper()
+
+	s := &stub{
+		clientID:      "eiga",
+		subject:       "stub-user-1",
+		email:         "stub@example.com",
+		emailVerified: true,
+		codes:         make(map[string]authorization),
+	}
+
+	var err error
+	s.key, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwksRequests := &atomic.Int64{}
+	routes := s.routes()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/jwks" {
+			jwksRequests.Add(1)
+		}
+		routes.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	s.issuer = server.URL
+
+	provider, err := oidc.Discover(context.Background(), s.issuer, s.clientID, "", "http://localhost/v1/auth/oidc/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, provider, jwksRequests
+}
+
+func (s *stub) idToken(t *testing.T, nonce string) string {
+	t.Helper()
+	return s.signClaims(t, s.claims(nonce))
+}
+
+// Les claims d'un ID token valide, que les tests peuvent modifier avant de signer
+func (s *stub) claims(nonce string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":            s.issuer,
+		"sub":            s.subject,
+		"aud":            s.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Minute).Unix(),
+		"nonce":          nonce,
+		"email":          s.email,
+		"email_verified": s.emailVerified,
+	}
+}
+
+func (s *stub) signClaims(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+
+	token, err := s.sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// Le code emis par /authorize pour ce verifier, sans suivre la redirection
+func authorizationCode(t *testing.T, provider *oidc.Provider, nonce, codeVerifier string) string {
+	t.Helper()
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	res, err := client.Get(provider.AuthCodeURL("state", nonce, codeVerifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	location, err := res.Location()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := location.Query().Get("code")
+	if code == "" {
+		t.Fatalf("no code in the redirection to %s", location)
+	}
+	return code
+}
+
+func TestVerifyStubIDToken(t *testing.T) {
+	s, provider, _ := startStub(t)
+
+	claims, err := provider.Verify(context.Background(), s.idToken(t, "nonce"), "nonce")
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	if claims.Subject != s.subject || claims.Email != s.email || !claims.EmailVerified {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	if _, err := provider.Verify(context.Background(), s.idToken(t, "nonce"), "other"); !errors.Is(err, oidc.ErrInvalidIDToken) {
+		t.Errorf("Verify(wrong nonce) = %v, want ErrInvalidIDToken", err)
+	}
+}
+
+func TestVerifyRejectsInvalidClaims(t *testing.T) {
+	s, provider, _ := startStub(t)
+
+	tests := []struct {
+		name   string
+		change func(claims map[string]interface{})
+		nonce  string
+	}{
+		{"wrong issuer", func(c map[string]interface{}) { c["iss"] = "https://attacker.example.com" }, "nonce"},
+		{"wrong audience", func(c map[string]interface{}) { c["aud"] = "other-client" }, "nonce"},
+		{"audience list without the client", func(c map[string]interface{}) { c["aud"] = []string{"other-client"} }, "nonce"},
+		{"nonce mismatch", func(c map[string]interface{}) {}, "other"},
+		{"missing nonce", func(c map[string]interface{}) { delete(c, "nonce") }, "nonce"},
+		{"expired", func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Second).Unix() }, "nonce"},
+		{"missing subject", func(c map[string]interface{}) { delete(c, "sub") }, "nonce"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := s.claims("nonce")
+			tt.change(claims)
+
+			_, err := provider.Verify(context.Background(), s.signClaims(t, claims), tt.nonce)
+			if !errors.Is(err, oidc.ErrInvalidIDToken) {
+				t.Errorf("Verify = %v, want ErrInvalidIDToken", err)
+			}
+		})
+	}
+}
+
+// Le stub refuse d'echanger le code sans le verifier PKCE qui l'a demande
+func TestExchangeChecksPKCEVerifier(t *testing.T) {
+	_, provider, _ := startStub(t)
+
+	tests := []struct {
+		name     string
+		verifier func(codeVerifier string) string
+		wantErr  error
+	}{
+		{"matching verifier", func(v string) string { return v }, nil},
+		{"other verifier", func(v string) string { return v + "x" }, oidc.ErrExchangeFailed},
+		{"no verifier", func(string) string { return "" }, oidc.ErrExchangeFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codeVerifier, err := oidc.RandomString()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			code := authorizationCode(t, provider, "nonce", codeVerifier)
+
+			_, err = provider.Exchange(context.Background(), code, tt.verifier(codeVerifier), "nonce")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Exchange = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Des tokens avec un kid inconnu ne doivent pas declencher un telechargement des cles chacun
+func TestUnknownKeyIDDoesNotHammerJWKS(t *testing.T) {
+	s, provider, jwksRequests := startStub(t)
+
+	if _, err := provider.Verify(context.Background(), s.idToken(t, "nonce"), "nonce"); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	parts := strings.Split(s.idToken(t, "nonce"), ".")
+	parts[0] = encoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT","kid":"unknown"}`))
+	forged := strings.Join(parts, ".")
+
+	for i := 0; i < 20; i++ {
+		if _, err := provider.Verify(context.Background(), forged, "nonce"); !errors.Is(err, oidc.ErrInvalidIDToken) {
+			t.Fatalf("Verify(unknown kid) = %v, want ErrInvalidIDToken", err)
+		}
+	}
+
+	if got := jwksRequests.Load(); got != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", got)
+	}
+
+	// Les cles connues restent utilisables
+	if _, err := provider.Verify(context.Background(), s.idToken(t, "nonce"), "nonce"); err != nil {
+		t.Errorf("Verify after unknown kids: %v", err)
+	}
+}
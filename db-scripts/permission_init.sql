@@ -13,6 +13,16 @@ VALUES (
 		);
 
 
+-- 「konas@memes.com」に admin ロールを与える (users:admin, roles:admin を含む)
+-- その後は /v1/admin/users で権限を管理できる
+INSERT INTO users_roles
+VALUES (
+		(SELECT id FROM users WHERE users.email='konas@memes.com'),
+		(SELECT id FROM roles WHERE roles.name='admin')
+		)
+ON CONFLICT DO NOTHING;
+
+
 -- List all activated users and their permissions
 SELECT email, array_agg(p.code) as permi
 FROM permissions as p
@@ -3,11 +3,15 @@ module github.com/VladimirArtyom/rest_eiga_api
 go 1.22.4
 
 require (
-	github.com/go-mail/mail/v2 v2.3.0 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	github.com/julienschmidt/httprouter v1.3.0 // indirect
-	github.com/lib/pq v1.10.2 // indirect
-	golang.org/x/crypto v0.30.0 // indirect
-	golang.org/x/time v0.10.0 // indirect
+	github.com/go-mail/mail/v2 v2.3.0
+	github.com/joho/godotenv v1.5.1
+	github.com/julienschmidt/httprouter v1.3.0
+	github.com/lib/pq v1.10.2
+	golang.org/x/crypto v0.30.0
+	golang.org/x/time v0.10.0
+)
+
+require (
+	golang.org/x/sys v0.28.0 // indirect
 	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
 )
@@ -0,0 +1,295 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
+	"github.com/lib/pq"
+)
+
+// Une cle ressemble a "eiga_<prefix>_<secret>"
+const (
+	apiKeyTag          = "eiga"
+	apiKeyPrefixLength = 5  // 8 caracteres en base32
+	apiKeySecretLength = 16 // 26 caracteres en base32, comme les tokens
+)
+
+var ErrDuplicateAPIKeyName = errors.New("duplicate api key name")
+
+var apiKeyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type APIKey struct {
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"-"`
+	Name        string     `json:"name"`
+	Plaintext   string     `json:"key,omitempty"` // seulement a la creation
+	Prefix      string     `json:"prefix"`
+	Hash        []byte     `json:"-"`
+	Permissions []string   `json:"permissions"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	Expiry      *time.Time `json:"expiry"`
+}
+
+type APIKeyModel struct {
+	DB DBTX
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(key.Permissions) > 0, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+}
+
+// Splits "eiga_<prefix>_<secret>", ok is false if the key is malformed
+func parseAPIKey(plaintext string) (prefix string, secret string, ok bool) {
+	parts := strings.Split(plaintext, "_")
+	if len(parts) != 3 || parts[0] != apiKeyTag {
+		return "", "", false
+	}
+
+	if len(parts[1]) != apiKeyEncoding.EncodedLen(apiKeyPrefixLength) ||
+		len(parts[2]) != apiKeyEncoding.EncodedLen(apiKeySecretLength) {
+		return "", "", false
+	}
+
+	return parts[1], parts[2], true
+}
+
+func generateAPIKey(userID int64, name string, permissions []string, expiry *time.Time) (*APIKey, error) {
+	var randomBytes []byte = make([]byte, apiKeyPrefixLength+apiKeySecretLength)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := apiKeyEncoding.EncodeToString(randomBytes[:apiKeyPrefixLength])
+	secret := apiKeyEncoding.EncodeToString(randomBytes[apiKeyPrefixLength:])
+
+	var hash [32]byte = sha256.Sum256([]byte(secret))
+
+	return &APIKey{
+		UserID:      userID,
+		Name:        name,
+		Plaintext:   apiKeyTag + "_" + prefix + "_" + secret,
+		Prefix:      prefix,
+		Hash:        hash[:],
+		Permissions: permissions,
+		Expiry:      expiry,
+	}, nil
+}
+
+func (m *APIKeyModel) New(userID int64, name string, permissions []string, expiry *time.Time) (*APIKey, error) {
+	key, err := generateAPIKey(userID, name, permissions, expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, hash, permissions, expiry)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+
+	args := []interface{}{
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		key.Hash,
+		pq.Array(key.Permissions),
+		key.Expiry,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		pqErr, ok := err.(*pq.Error)
+		if ok && pqErr.Code.Name() == "unique_violation" && pqErr.Constraint == "api_keys_user_name_key" {
+			return nil, ErrDuplicateAPIKeyName
+		}
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (m *APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+		SELECT id, user_id, name, prefix, permissions, created_at, last_used_at, expiry
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		var key APIKey
+
+		err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.Name,
+			&key.Prefix,
+			pq.Array(&key.Permissions),
+			&key.CreatedAt,
+			&key.LastUsedAt,
+			&key.Expiry,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Retrouve une cle valide et son proprietaire. Le secret est compare en temps constant.
+func (m *APIKeyModel) GetForKey(plaintext string) (*APIKey, *User, error) {
+	prefix, secret, ok := parseAPIKey(plaintext)
+	if !ok {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT k.id, k.name, k.prefix, k.hash, k.permissions, k.created_at, k.last_used_at, k.expiry,
+			u.id, u.created_at, u.name, u.email, u.password_hash, u.activated, u.locked, u.version
+		FROM api_keys as k
+		INNER JOIN users as u
+		ON u.id = k.user_id
+		WHERE k.prefix = $1
+		AND (k.expiry IS NULL OR k.expiry > now())`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var key APIKey
+	var user User
+
+	err := m.DB.QueryRowContext(ctx, query, prefix).Scan(
+		&key.ID,
+		&key.Name,
+		&key.Prefix,
+		&key.Hash,
+		pq.Array(&key.Permissions),
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.Expiry,
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Locked,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	hash := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare(hash[:], key.Hash) != 1 {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	key.UserID = user.ID
+
+	return &key, &user, nil
+}
+
+func (m *APIKeyModel) Touch(id int64) error {
+	query := `
+		UPDATE api_keys
+		SET last_used_at = now()
+		WHERE id = $1
+		AND (last_used_at IS NULL OR last_used_at < now() - INTERVAL '1 minute')`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// Revoque toutes les cles d'un utilisateur
+func (m *APIKeyModel) DeleteAllForUser(userID int64) error {
+	query := `
+		DELETE FROM api_keys
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+func (m *APIKeyModel) DeleteForUser(id int64, userID int64) error {
+	query := `
+		DELETE FROM api_keys
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Les permissions effectives d'une cle: celles de la cle que le proprietaire a encore
+func (k *APIKey) EffectivePermissions(owner Permissions) Permissions {
+	effective := Permissions{}
+	for _, code := range k.Permissions {
+		if owner.Include(code) {
+			effective = append(effective, code)
+		}
+	}
+	return effective
+}
@@ -0,0 +1,144 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type AuditEntry struct {
+	ID           int64           `json:"id"`
+	CreatedAt    time.Time       `json:"created_at"`
+	ActorID      *int64          `json:"actor_id"` // nil pour une requete anonyme
+	Action       string          `json:"action"`
+	ResourceType string          `json:"resource_type"`
+	ResourceID   string          `json:"resource_id,omitempty"`
+	RequestID    string          `json:"request_id,omitempty"`
+	IP           string          `json:"ip,omitempty"`
+	Before       json.RawMessage `json:"before,omitempty"`
+	After        json.RawMessage `json:"after,omitempty"`
+}
+
+// Les criteres vides ne filtrent pas
+type AuditFilter struct {
+	ActorID      int64
+	Action       string
+	ResourceType string
+	ResourceID   string
+	RequestID    string
+	Since        time.Time
+	Until        time.Time
+}
+
+type AuditModel struct {
+	DB DBTX
+}
+
+func (m *AuditModel) Insert(entry *AuditEntry) error {
+	query := `
+		INSERT INTO audit_log (actor_id, action, resource_type, resource_id, request_id, ip, before, after)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{
+		entry.ActorID,
+		entry.Action,
+		entry.ResourceType,
+		entry.ResourceID,
+		entry.RequestID,
+		entry.IP,
+		nullableJSON(entry.Before),
+		nullableJSON(entry.After),
+	}
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+func (m *AuditModel) GetAll(filter AuditFilter, filters Filters) ([]*AuditEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, created_at, actor_id, action, resource_type, resource_id, request_id, ip, before, after
+		FROM audit_log
+		WHERE (actor_id = $1 OR $1 = 0)
+		AND (action = $2 OR $2 = '')
+		AND (resource_type = $3 OR $3 = '')
+		AND (resource_id = $4 OR $4 = '')
+		AND (request_id = $5 OR $5 = '')
+		AND (created_at >= $6 OR $6 IS NULL)
+		AND (created_at < $7 OR $7 IS NULL)
+		ORDER BY %s %s, id ASC
+		LIMIT $8 OFFSET $9`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{
+		filter.ActorID,
+		filter.Action,
+		filter.ResourceType,
+		filter.ResourceID,
+		filter.RequestID,
+		nullableTime(filter.Since),
+		nullableTime(filter.Until),
+		filters.limit(),
+		filters.offset(),
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	entries := []*AuditEntry{}
+	totalRecords := 0
+	for rows.Next() {
+		var entry AuditEntry
+		var before, after []byte
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.ID,
+			&entry.CreatedAt,
+			&entry.ActorID,
+			&entry.Action,
+			&entry.ResourceType,
+			&entry.ResourceID,
+			&entry.RequestID,
+			&entry.IP,
+			&before,
+			&after,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entry.Before = before
+		entry.After = after
+
+		entries = append(entries, &entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return entries, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func nullableJSON(value json.RawMessage) interface{} {
+	if len(value) == 0 {
+		return nil
+	}
+	return string(value)
+}
+
+func nullableTime(value time.Time) interface{} {
+	if value.IsZero() {
+		return nil
+	}
+	return value
+}
@@ -73,3 +73,10 @@ func (f Filters) limit() int {
 func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Echappe les jokers de LIKE, a utiliser avec ESCAPE '\'
+func escapeLike(term string) string {
+	return likeEscaper.Replace(term)
+}
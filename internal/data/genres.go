@@ -0,0 +1,107 @@
+package data
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Tout ce qui n'est ni une lettre ni un chiffre, quelle que soit l'ecriture
+var genreSeparatorRX = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+type Genre struct {
+	Slug       string `json:"slug"`
+	Name       string `json:"name"`
+	MovieCount int    `json:"movie_count"`
+}
+
+// Slug ou alias normalise -> slug canonique
+type GenreCatalogue map[string]string
+
+type GenreModel struct {
+	DB DBTX
+}
+
+// "Science Fiction" et "science_fiction" donnent "science-fiction", "アクション" reste "アクション"
+func NormalizeGenre(genre string) string {
+	return strings.Trim(genreSeparatorRX.ReplaceAllString(strings.ToLower(genre), "-"), "-")
+}
+
+// Renvoie le slug canonique du genre, ou false s'il est inconnu
+func (c GenreCatalogue) Canonical(genre string) (string, bool) {
+	slug, ok := c[NormalizeGenre(genre)]
+	return slug, ok
+}
+
+func (m *GenreModel) Catalogue() (GenreCatalogue, error) {
+	query := `
+		SELECT slug, slug FROM genres
+		UNION ALL
+		SELECT alias, slug FROM genre_aliases`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	catalogue := GenreCatalogue{}
+	for rows.Next() {
+		var key, slug string
+
+		err := rows.Scan(&key, &slug)
+		if err != nil {
+			return nil, err
+		}
+
+		catalogue[key] = slug
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return catalogue, nil
+}
+
+func (m *GenreModel) GetAll() ([]*Genre, error) {
+	query := `
+		SELECT g.slug, g.name, COUNT(m.id)
+		FROM genres as g
+		LEFT JOIN movies as m
+		ON m.genres @> ARRAY[g.slug]
+		AND m.deleted_at IS NULL
+		GROUP BY g.slug, g.name
+		ORDER BY g.slug`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(&genre.Slug, &genre.Name, &genre.MovieCount)
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &genre)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
@@ -0,0 +1,45 @@
+package data
+
+import "testing"
+
+func TestNormalizeGenre(t *testing.T) {
+	tests := map[string]string{
+		"Science Fiction":    "science-fiction",
+		"science_fiction":    "science-fiction",
+		"  Sci-Fi!  ":        "sci-fi",
+		"アクション":              "アクション",
+		"SF・ファンタジー":          "sf-ファンタジー",
+		"Comédie Dramatique": "comédie-dramatique",
+		"!!!":                "",
+	}
+
+	for genre, want := range tests {
+		if got := NormalizeGenre(genre); got != want {
+			t.Errorf("NormalizeGenre(%q) = %q, want %q", genre, got, want)
+		}
+	}
+}
+
+func TestGenreCatalogueCanonical(t *testing.T) {
+	catalogue := GenreCatalogue{
+		"science-fiction": "science-fiction",
+		"sci-fi":          "science-fiction",
+		"アクション":           "アクション",
+	}
+
+	tests := map[string]string{
+		"Sci Fi":          "science-fiction",
+		"SCIENCE FICTION": "science-fiction",
+		"アクション":           "アクション",
+	}
+
+	for genre, want := range tests {
+		if got, ok := catalogue.Canonical(genre); !ok || got != want {
+			t.Errorf("Canonical(%q) = (%q, %v), want (%q, true)", genre, got, ok, want)
+		}
+	}
+
+	if _, ok := catalogue.Canonical("!!!"); ok {
+		t.Error("Canonical(\"!!!\") found a genre")
+	}
+}
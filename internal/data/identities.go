@@ -0,0 +1,162 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Ce qu'il faut garder entre /v1/auth/oidc/login et le callback
+type OIDCState struct {
+	CodeVerifier string
+	Nonce        string
+}
+
+// Un compte chez un fournisseur d'identite, lie a un utilisateur
+type Identity struct {
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type IdentityModel struct {
+	DB DBTX
+}
+
+// Le state est stocke hashe, comme les tokens
+func (m *IdentityModel) InsertState(state string, oidcState *OIDCState, ttl time.Duration) error {
+	hash := sha256.Sum256([]byte(state))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO oidc_states (hash, code_verifier, nonce, expiry)
+		VALUES ($1, $2, $3, $4)`
+
+	_, err := m.DB.ExecContext(ctx, query, hash[:], oidcState.CodeVerifier, oidcState.Nonce, time.Now().Add(ttl))
+	if err != nil {
+		return err
+	}
+
+	// Les connexions abandonnees ne restent pas
+	_, err = m.DB.ExecContext(ctx, `DELETE FROM oidc_states WHERE expiry < now()`)
+	return err
+}
+
+// Un state ne sert qu'une fois: il est supprime quand il est lu
+func (m *IdentityModel) ConsumeState(state string) (*OIDCState, error) {
+	hash := sha256.Sum256([]byte(state))
+
+	query := `
+		DELETE FROM oidc_states
+		WHERE hash = $1
+		RETURNING code_verifier, nonce, expiry`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var oidcState OIDCState
+	var expiry time.Time
+	err := m.DB.QueryRowContext(ctx, query, hash[:]).Scan(&oidcState.CodeVerifier, &oidcState.Nonce, &expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if time.Now().After(expiry) {
+		return nil, ErrRecordNotFound
+	}
+
+	return &oidcState, nil
+}
+
+// Returns the user linked to the (issuer, subject) identity
+func (m *IdentityModel) GetUser(issuer string, subject string) (*User, error) {
+	query := `
+		SELECT u.id, u.created_at, u.name, u.email, u.password_hash, u.activated, u.locked, u.version
+		FROM users as u
+		INNER JOIN users_identities as ui
+		ON u.id = ui.user_id
+		WHERE ui.issuer = $1
+		AND ui.subject = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var user User
+	err := m.DB.QueryRowContext(ctx, query, issuer, subject).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Locked,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+func (m *IdentityModel) Link(issuer string, subject string, userID int64) error {
+	query := `
+		INSERT INTO users_identities (issuer, subject, user_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, issuer, subject, userID)
+	return err
+}
+
+func (m *IdentityModel) GetAllForUser(userID int64) ([]*Identity, error) {
+	query := `
+		SELECT issuer, subject, created_at
+		FROM users_identities
+		WHERE user_id = $1
+		ORDER BY created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*Identity{}
+	for rows.Next() {
+		var identity Identity
+
+		err := rows.Scan(&identity.Issuer, &identity.Subject, &identity.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		identities = append(identities, &identity)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
@@ -0,0 +1,116 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Les echecs de connexion recents pour un email
+type LoginFailures struct {
+	Account     int       // tous les echecs pour cet email
+	IP          int       // les echecs pour cet email depuis cette IP
+	LastFailure time.Time // zero s'il n'y en a pas
+}
+
+type LoginAttemptModel struct {
+	DB DBTX
+}
+
+// Counts the failures for an email, and for the email from one IP, since the
+// beginning of the window.
+func (m *LoginAttemptModel) Failures(email string, ip string, window time.Duration) (LoginFailures, error) {
+	query := `
+		SELECT COUNT(*),
+			COUNT(*) FILTER (WHERE ip = $2),
+			COALESCE(MAX(created_at), 'epoch')
+		FROM login_failures
+		WHERE email = $1
+		AND created_at > $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var failures LoginFailures
+	err := m.DB.QueryRowContext(ctx, query, email, ip, time.Now().Add(-window)).Scan(
+		&failures.Account,
+		&failures.IP,
+		&failures.LastFailure,
+	)
+	if err != nil {
+		return LoginFailures{}, err
+	}
+
+	if failures.Account == 0 {
+		failures.LastFailure = time.Time{}
+	}
+
+	return failures, nil
+}
+
+func (m *LoginAttemptModel) RecordFailure(email string, ip string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `INSERT INTO login_failures (email, ip) VALUES ($1, $2)`, email, ip)
+	if err != nil {
+		return err
+	}
+
+	// On ne garde pas l'historique plus d'un jour
+	_, err = m.DB.ExecContext(ctx, `DELETE FROM login_failures WHERE created_at < now() - INTERVAL '1 day'`)
+	return err
+}
+
+// Apres une connexion reussie ou un deverrouillage, on repart de zero
+func (m *LoginAttemptModel) Clear(email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM login_failures WHERE email = $1`, email)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.ExecContext(ctx, `DELETE FROM login_lockouts WHERE email = $1`, email)
+	return err
+}
+
+func (m *LoginAttemptModel) Lock(email string, until time.Time) error {
+	query := `
+		INSERT INTO login_lockouts (email, locked_until)
+		VALUES ($1, $2)
+		ON CONFLICT (email) DO UPDATE SET locked_until = EXCLUDED.locked_until`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email, until)
+	return err
+}
+
+// Returns the end of the lockout, or nil if the email is not locked
+func (m *LoginAttemptModel) LockedUntil(email string) (*time.Time, error) {
+	query := `
+		SELECT locked_until
+		FROM login_lockouts
+		WHERE email = $1
+		AND locked_until > now()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var lockedUntil time.Time
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(&lockedUntil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	return &lockedUntil, nil
+}
@@ -1,8 +1,10 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 )
 
 var (
@@ -10,16 +12,40 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
+// Ce que les models utilisent pour parler a la base: la connexion, ou la
+// transaction de Models.WithTx
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 type Models struct {
+	db *sql.DB
+
 	Movies MovieModel
 	Users  UserModel
 	Tokens TokenModel
 	Permissions PermissionModel
+	APIKeys APIKeyModel
+	Roles RoleModel
+	LoginAttempts LoginAttemptModel
+	TwoFactors TwoFactorModel
+	Identities IdentityModel
+	Reviews ReviewModel
+	Watchlist WatchlistModel
+	Watched WatchedModel
+	People PersonModel
+	Credits CreditModel
+	Genres GenreModel
+	MovieRevisions MovieRevisionModel
+	Audit AuditModel
 }
 
 // Return a new instance of Models
 func NewModels(db *sql.DB) Models {
 	return Models{
+		db: db,
 		Movies: MovieModel{
 			DB: db,
 		},
@@ -32,6 +58,144 @@ func NewModels(db *sql.DB) Models {
 		Permissions: PermissionModel{
 			DB: db,
 		},
+		APIKeys: APIKeyModel{
+			DB: db,
+		},
+		Roles: RoleModel{
+			DB: db,
+		},
+		LoginAttempts: LoginAttemptModel{
+			DB: db,
+		},
+		TwoFactors: TwoFactorModel{
+			DB: db,
+		},
+		Identities: IdentityModel{
+			DB: db,
+		},
+		Reviews: ReviewModel{
+			DB: db,
+		},
+		Watchlist: WatchlistModel{
+			DB: db,
+		},
+		Watched: WatchedModel{
+			DB: db,
+		},
+		People: PersonModel{
+			DB: db,
+		},
+		Credits: CreditModel{
+			DB: db,
+		},
+		Genres: GenreModel{
+			DB: db,
+		},
+		MovieRevisions: MovieRevisionModel{
+			DB: db,
+		},
+		Audit: AuditModel{
+			DB: db,
+		},
+
+	}
+}
+
+// Partage le meme cache de permissions entre les models qui le modifient
+func (m *Models) SetPermissionCache(cache *PermissionCache) {
+	m.Permissions.Cache = cache
+	m.Roles.PermissionCache = cache
+}
+
+// Execute fn avec des models qui ecrivent tous dans la meme transaction, par
+// exemple une modification et son entree d'audit. La transaction n'est validee
+// que si fn ne renvoie pas d'erreur.
+func (m Models) WithTx(fn func(tx Models) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Le cache ne voit rien avant le commit: une lecture entre temps remettrait
+	// les anciennes permissions en cache
+	cache := newTxPermissionCache()
+
+	txModels := m.withDB(tx)
+	txModels.SetPermissionCache(cache)
+
+	err = fn(txModels)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range cache.pending {
+		m.Permissions.Cache.Invalidate(userID)
+	}
+
+	return nil
+}
+
+func (m Models) withDB(db DBTX) Models {
+	m.Movies.DB = db
+	m.Users.DB = db
+	m.Tokens.DB = db
+	m.Permissions.DB = db
+	m.APIKeys.DB = db
+	m.Roles.DB = db
+	m.LoginAttempts.DB = db
+	m.TwoFactors.DB = db
+	m.Identities.DB = db
+	m.Reviews.DB = db
+	m.Watchlist.DB = db
+	m.Watched.DB = db
+	m.People.DB = db
+	m.Credits.DB = db
+	m.Genres.DB = db
+	m.MovieRevisions.DB = db
+	m.Audit.DB = db
+	return m
+}
+
+// Une transaction commencee par un model. Dans Models.WithTx le model ecrit
+// dans la transaction en cours, que seul WithTx valide ou annule.
+type modelTx struct {
+	DBTX
+	tx *sql.Tx // nil dans Models.WithTx
+}
+
+func beginTx(ctx context.Context, db DBTX) (*modelTx, error) {
+	sqlDB, ok := db.(*sql.DB)
+	if !ok {
+		return &modelTx{DBTX: db}, nil
+	}
+
+	tx, err := sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &modelTx{DBTX: tx, tx: tx}, nil
+}
+
+func (t *modelTx) Commit() error {
+	if t.tx == nil {
+		return nil
+	}
+	return t.tx.Commit()
+}
 
+func (t *modelTx) Rollback() error {
+	if t.tx == nil {
+		return nil
 	}
+	return t.tx.Rollback()
 }
@@ -0,0 +1,81 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Une erreur dans fn annule la modification et son entree d'audit
+func TestWithTxRollsBackChangeAndAudit(t *testing.T) {
+	db := openTestDB(t)
+	m := NewModels(db)
+	m.SetPermissionCache(NewPermissionCache(time.Minute))
+
+	userID := insertTestUser(t, db, "withtx-rollback@example.com")
+	t.Cleanup(func() { db.Exec(`DELETE FROM audit_log WHERE actor_id = $1`, userID) })
+
+	errAbort := errors.New("abort")
+
+	err := m.WithTx(func(tx Models) error {
+		err := tx.Permissions.AddForUser(userID, "movies:read")
+		if err != nil {
+			return err
+		}
+
+		err = tx.Audit.Insert(&AuditEntry{ActorID: &userID, Action: "update", ResourceType: "permission"})
+		if err != nil {
+			return err
+		}
+
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("WithTx = %v, want errAbort", err)
+	}
+
+	permissions, err := m.Permissions.GetAllForUser(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if permissions.Include("movies:read") {
+		t.Error("the permission survived the rollback")
+	}
+
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM audit_log WHERE actor_id = $1`, userID).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("%d audit entries survived the rollback", count)
+	}
+}
+
+// Le cache n'est invalide qu'au commit
+func TestWithTxInvalidatesCacheAfterCommit(t *testing.T) {
+	db := openTestDB(t)
+	m := NewModels(db)
+	m.SetPermissionCache(NewPermissionCache(time.Minute))
+
+	userID := insertTestUser(t, db, "withtx-commit@example.com")
+
+	if _, err := m.Permissions.GetAllForUser(userID); err != nil {
+		t.Fatal(err)
+	}
+
+	err := m.WithTx(func(tx Models) error {
+		return tx.Permissions.AddForUser(userID, "movies:read")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	permissions, err := m.Permissions.GetAllForUser(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !permissions.Include("movies:read") {
+		t.Errorf("GetAllForUser = %v after the commit", permissions)
+	}
+}
@@ -19,13 +19,27 @@ type Movie struct {
 	Genres    []string  `json:"genres,omitempty"`
 	Version   int32     `json:"version"`
 	CreatedAt time.Time `json:"-"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"` // nil hors de la corbeille
+	// Calcules a partir des critiques, jamais ecrits
+	AverageRating float64 `json:"average_rating"`
+	ReviewCount   int     `json:"review_count"`
 }
 
+// La note moyenne (arrondie a 0.1) et le nombre de critiques de chaque film
+const movieRatingsJoin = `
+		LEFT JOIN (
+			SELECT movie_id, ROUND(AVG(rating), 1)::float8 as average_rating, COUNT(*) as review_count
+			FROM reviews
+			GROUP BY movie_id
+		) as r
+		ON r.movie_id = m.id`
+
 type MovieModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
-func (m *MovieModel) Insert(movie *Movie) error {
+// userID est l'auteur de la premiere revision
+func (m *MovieModel) Insert(movie *Movie, userID int64) error {
 	query := `
 		INSERT INTO movies (title, year, runtime, genres) 
 		VALUES ($1, $2, $3, $4) 
@@ -36,23 +50,47 @@ func (m *MovieModel) Insert(movie *Movie) error {
 
 	defer cancel()
 
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	var args []interface{}
 	args = append(args, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)) // Make sure that each datatype has been supported by the database to read.
 
 	// Save the returning variables to existing movie.
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		return err
+	}
+
+	err = insertMovieRevision(ctx, tx, movie, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (m *MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// personID a 0 ne filtre pas par personne
+func (m *MovieModel) GetAll(title string, genres []string, personID int64, filters Filters) ([]*Movie, Metadata, error) {
 
 	query := fmt.Sprintf(`
-		SELECT COUNT(*) OVER(), id, created_at, title, year, runtime, genres, version
-		FROM movies
-		WHERE (to_tsvector(title) @@ plainto_tsquery('simple', $1) OR $1 = '')  
-		AND (genres @> $2 OR $2 = ARRAY[]::TEXT[] )
-		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4
-	`, filters.sortColumn(),
+		SELECT COUNT(*) OVER(), m.id, m.created_at, m.title, m.year, m.runtime, m.genres, m.version,
+			COALESCE(r.average_rating, 0) as average_rating, COALESCE(r.review_count, 0) as review_count
+		FROM movies as m
+		%s
+		WHERE (to_tsvector(m.title) @@ plainto_tsquery('simple', $1) OR $1 = '')  
+		AND (m.genres @> $2 OR $2 = ARRAY[]::TEXT[] )
+		AND m.deleted_at IS NULL
+		AND ($3 = 0 OR EXISTS (
+			SELECT 1 FROM movie_credits as c
+			WHERE c.movie_id = m.id AND c.person_id = $3))
+		ORDER BY %s %s, m.id ASC
+		LIMIT $4 OFFSET $5
+	`, movieRatingsJoin,
+		filters.sortColumn(),
 		filters.sortDirection()) // Using no stemming approach for tsquery
 
 	// creer une context avec 3-seconds timeout
@@ -62,6 +100,7 @@ func (m *MovieModel) GetAll(title string, genres []string, filters Filters) ([]*
 	args := []any{
 		title,
 		pq.Array(genres),
+		personID,
 		filters.limit(),
 		filters.offset(),
 	}
@@ -85,6 +124,8 @@ func (m *MovieModel) GetAll(title string, genres []string, filters Filters) ([]*
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
 			&movie.Version,
+			&movie.AverageRating,
+			&movie.ReviewCount,
 		)
 
 		if err != nil {
@@ -128,9 +169,11 @@ func (m *MovieModel) Get(id int64) (*Movie, error) {
 			pq.Array(&movie.Genres), &movie.Version)
 	*/
 	query := `
-		SELECT id, created_at, title, year, runtime, genres, version
-		from movies
-		WHERE id=$1
+		SELECT m.id, m.created_at, m.title, m.year, m.runtime, m.genres, m.version,
+			COALESCE(r.average_rating, 0), COALESCE(r.review_count, 0)
+		from movies as m
+		` + movieRatingsJoin + `
+		WHERE m.id=$1 AND m.deleted_at IS NULL
 	`
 	var movie Movie = Movie{}
 
@@ -141,6 +184,7 @@ func (m *MovieModel) Get(id int64) (*Movie, error) {
 		&movie.ID, &movie.CreatedAt,
 		&movie.Title, &movie.Year, &movie.Runtime,
 		pq.Array(&movie.Genres), &movie.Version,
+		&movie.AverageRating, &movie.ReviewCount,
 	)
 
 	if err != nil {
@@ -155,17 +199,24 @@ func (m *MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-func (m *MovieModel) Update(movie *Movie) error {
+// userID est l'auteur de la nouvelle revision
+func (m *MovieModel) Update(movie *Movie, userID int64) error {
 	var query string = `
 		UPDATE movies
 		SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-		WHERE id = $5 AND version = $6
+		WHERE id = $5 AND version = $6 AND deleted_at IS NULL
 		RETURNING version
 	`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	args := []interface{}{
 		movie.Title,
 		movie.Year,
@@ -175,7 +226,7 @@ func (m *MovieModel) Update(movie *Movie) error {
 		movie.Version,
 	}
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+	err = tx.QueryRowContext(ctx, query, args...).Scan(
 		&movie.Version,
 	)
 	if err != nil {
@@ -186,16 +237,24 @@ func (m *MovieModel) Update(movie *Movie) error {
 			return err
 		}
 	}
-	return nil
+
+	err = insertMovieRevision(ctx, tx, movie, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (m *MovieModel) Delete(id int64) error {
 	if id < 0 {
 		return ErrRecordNotFound
 	}
+	// Le film part a la corbeille, il est purge apres la retention
 	query := `
-		DELETE FROM movies
-		WHERE id = $1
+		UPDATE movies
+		SET deleted_at = now()
+		WHERE id = $1 AND deleted_at IS NULL
 	`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -218,7 +277,101 @@ func (m *MovieModel) Delete(id int64) error {
 	return nil
 }
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
+// Les films de la corbeille
+func (m *MovieModel) GetAllTrashed(filters Filters) ([]*Movie, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, created_at, title, year, runtime, genres, version, deleted_at
+		FROM movies
+		WHERE deleted_at IS NOT NULL
+		ORDER BY %s %s, id ASC
+		LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+	totalRecords := 0
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.DeletedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return movies, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Sort le film de la corbeille, sans nouvelle version puisque son contenu ne change pas
+func (m *MovieModel) Restore(id int64) error {
+	query := `
+		UPDATE movies
+		SET deleted_at = NULL
+		WHERE id = $1 AND deleted_at IS NOT NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Supprime definitivement les films mis a la corbeille avant trashedBefore
+func (m *MovieModel) Purge(trashedBefore time.Time) (int64, error) {
+	query := `
+		DELETE FROM movies
+		WHERE deleted_at IS NOT NULL
+		AND deleted_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, trashedBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Les genres du film sont remplaces par leurs slugs canoniques du catalogue
+func ValidateMovie(v *validator.Validator, movie *Movie, catalogue GenreCatalogue) {
 
 	//validate the input
 
@@ -237,6 +390,16 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(movie.Genres != nil, "genres", "must be provided")
 	v.Check(len(movie.Genres) > 0, "genres", "must contain at least 1 genre")
 	v.Check(len(movie.Genres) < 6, "genres", "must not contain more than 5 genres")
+
+	for i, genre := range movie.Genres {
+		slug, ok := catalogue.Canonical(genre)
+		if !ok {
+			v.AddError("genres", fmt.Sprintf("unknown genre %q", genre))
+			continue
+		}
+		movie.Genres[i] = slug
+	}
+
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
 
 }
@@ -1,34 +1,97 @@
 package data
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
 	"errors"
+	"fmt"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
 type password struct {
-	plaintext *string 
-	hash []byte 
+	plaintext *string
+	hash []byte
+	outdated bool
+}
+
+// A password hashing algorithm. The hashes are self-describing ("$2a$..." for
+// bcrypt, PHC strings "$argon2id$..." for argon2id), so password_hash can hold
+// hashes of several algorithms at the same time.
+type PasswordHasher interface {
+	Hash(plainTextPassword string) ([]byte, error)
+	Verify(hash []byte, plainTextPassword string) (bool, error)
+	// Whether the hash was produced by this algorithm
+	Identifies(hash []byte) bool
+	// Whether the hash was produced with other parameters than the current ones
+	NeedsRehash(hash []byte) bool
+}
+
+// Le hasher des nouveaux mots de passe, choisi au demarrage
+var currentHasher PasswordHasher = BcryptHasher{Cost: 12}
+
+// Les algorithmes que Matches sait encore verifier
+var knownHashers = []PasswordHasher{BcryptHasher{}, Argon2idHasher{}}
+
+func SetPasswordHasher(hasher PasswordHasher) {
+	currentHasher = hasher
 }
 
-// Calculates bcrypt hash and stores both the hash and the plaintext version in the struct
+// Calculates the hash with the current hasher and stores both the hash and the plaintext version in the struct
 
 func (p *password) Set(plainTextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plainTextPassword), 12)
+	hash, err := currentHasher.Hash(plainTextPassword)
 	if err != nil {
 		return err
 	}
 
 	p.plaintext = &plainTextPassword
 	p.hash = hash
+	p.outdated = false
 
 	return nil
 }
 
-// Checks whether the provided plaintext password matches the hashed passowrd stored 
-// in the struct
+// Checks whether the provided plaintext password matches the hashed passowrd stored
+// in the struct. After a match, Outdated() tells if the hash should be upgraded.
 func (p *password) Matches(plainTextPassword string) (bool, error) {
- 	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plainTextPassword))
+	for _, hasher := range knownHashers {
+		if !hasher.Identifies(p.hash) {
+			continue
+		}
+
+		matched, err := hasher.Verify(p.hash, plainTextPassword)
+		if err != nil || !matched {
+			return false, err
+		}
+
+		p.outdated = !currentHasher.Identifies(p.hash) || currentHasher.NeedsRehash(p.hash)
+		return true, nil
+	}
+
+	return false, ErrUnknownPasswordHash
+}
+
+// Le hash a ete calcule avec un autre algorithme ou d'autres parametres
+func (p *password) Outdated() bool {
+	return p.outdated
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(plainTextPassword string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(plainTextPassword), h.Cost)
+}
+
+func (h BcryptHasher) Verify(hash []byte, plainTextPassword string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(hash, []byte(plainTextPassword))
 	if err != nil {
 		switch {
 			case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
@@ -41,3 +104,100 @@ func (p *password) Matches(plainTextPassword string) (bool, error) {
 	return true, nil
 }
 
+func (h BcryptHasher) Identifies(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$2"))
+}
+
+func (h BcryptHasher) NeedsRehash(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	return err != nil || cost != h.Cost
+}
+
+// Memory est en KiB
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+var argon2Encoding = base64.RawStdEncoding
+
+// Le format PHC: $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func (h Argon2idHasher) Hash(plainTextPassword string) ([]byte, error) {
+	salt := make([]byte, argon2SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(plainTextPassword), salt, h.Iterations, h.Memory, h.Parallelism, argon2KeyLength)
+
+	encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		argon2Encoding.EncodeToString(salt), argon2Encoding.EncodeToString(key))
+
+	return []byte(encoded), nil
+}
+
+func (h Argon2idHasher) Verify(hash []byte, plainTextPassword string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+
+	otherKey := argon2.IDKey([]byte(plainTextPassword), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, otherKey) == 1, nil
+}
+
+func (h Argon2idHasher) Identifies(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$argon2id$"))
+}
+
+func (h Argon2idHasher) NeedsRehash(hash []byte) bool {
+	params, _, key, err := decodeArgon2id(hash)
+	return err != nil || params != h || len(key) != argon2KeyLength
+}
+
+func decodeArgon2id(hash []byte) (Argon2idHasher, []byte, []byte, error) {
+	var params Argon2idHasher
+
+	parts := bytes.Split(hash, []byte("$"))
+	if len(parts) != 6 {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(string(parts[2]), "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	_, err = fmt.Sscanf(string(parts[3]), "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	// argon2.IDKey panique avec t=0 ou p=0, et demande au moins 8*p KiB
+	if params.Iterations == 0 || params.Parallelism == 0 || params.Memory < 8*uint32(params.Parallelism) {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	salt, err := argon2Encoding.DecodeString(string(parts[4]))
+	if err != nil || len(salt) == 0 {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	// Une cle vide serait egale a n'importe quelle autre cle vide
+	key, err := argon2Encoding.DecodeString(string(parts[5]))
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrUnknownPasswordHash
+	}
+
+	return params, salt, key, nil
+}
@@ -0,0 +1,170 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
+)
+
+// Les regles appliquees a un nouveau mot de passe (inscription, reset, changement).
+// ValidatePasswordPlainText reste la verification minimale, aussi utilisee au login.
+type PasswordPolicy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	MaxRepeated   int  // caracteres identiques a la suite, 0 pour ne pas limiter
+	CheckBreached bool // refuse les mots de passe de la liste des fuites
+}
+
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:     8,
+	MaxRepeated:   3,
+	CheckBreached: true,
+}
+
+// Validate checks every rule and reports all the failed ones together under the
+// "password" key, separated by "; ". The user, if not nil, is used to refuse
+// passwords containing their name or email.
+func (p PasswordPolicy) Validate(v *validator.Validator, plaintext string, user *User) {
+	var failures []string
+	check := func(ok bool, message string) {
+		if ok {
+			return
+		}
+		for _, failure := range failures {
+			if failure == message {
+				return
+			}
+		}
+		failures = append(failures, message)
+	}
+
+	basic := validator.New()
+	ValidatePasswordPlainText(basic, plaintext)
+	check(basic.Valid(), basic.Errors["password"])
+
+	check(len(plaintext) >= p.MinLength, fmt.Sprintf("must be at least %d bytes long", p.MinLength))
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range plaintext {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case !unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	check(!p.RequireUpper || hasUpper, "must contain at least one uppercase letter")
+	check(!p.RequireLower || hasLower, "must contain at least one lowercase letter")
+	check(!p.RequireDigit || hasDigit, "must contain at least one digit")
+	check(!p.RequireSymbol || hasSymbol, "must contain at least one symbol")
+
+	if p.MaxRepeated > 0 {
+		check(longestRun(plaintext) <= p.MaxRepeated,
+			fmt.Sprintf("must not repeat the same character more than %d times in a row", p.MaxRepeated))
+	}
+
+	if user != nil {
+		check(!containsPersonalInfo(plaintext, user), "must not contain your name or email address")
+	}
+
+	if p.CheckBreached {
+		check(!IsBreachedPassword(plaintext), "is too common, it appears in a list of breached passwords")
+	}
+
+	// Remplace un message de ValidatePasswordPlainText deja ajoute, il fait partie de la liste
+	if len(failures) > 0 {
+		v.Errors["password"] = strings.Join(failures, "; ")
+	}
+}
+
+func longestRun(s string) int {
+	longest, current := 0, 0
+	var previous rune = -1
+	for _, r := range s {
+		if r == previous {
+			current++
+		} else {
+			current = 1
+		}
+		previous = r
+		if current > longest {
+			longest = current
+		}
+	}
+	return longest
+}
+
+// Les morceaux de moins de 3 caracteres sont ignores, "al" est dans trop de mots
+func containsPersonalInfo(plaintext string, user *User) bool {
+	password := strings.ToLower(plaintext)
+
+	parts := strings.Fields(strings.ToLower(user.Name))
+	if local, _, found := strings.Cut(strings.ToLower(user.Email), "@"); found {
+		parts = append(parts, local)
+	}
+
+	for _, part := range parts {
+		if len(part) >= 3 && strings.Contains(password, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// La liste est groupee par prefixe de 5 caracteres du SHA-1, comme l'API range
+// de Have I Been Pwned: elle pourra etre remplacee par l'API sans changer l'appel.
+//
+//go:embed passwords/breached_sha1.txt
+var breachedPasswordsFile []byte
+
+var (
+	breachedOnce     sync.Once
+	breachedSuffixes map[string]map[string]bool
+)
+
+func loadBreachedPasswords() {
+	breachedSuffixes = make(map[string]map[string]bool)
+
+	scanner := bufio.NewScanner(bytes.NewReader(breachedPasswordsFile))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		prefix, suffix, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+
+		if breachedSuffixes[prefix] == nil {
+			breachedSuffixes[prefix] = make(map[string]bool)
+		}
+		breachedSuffixes[prefix][suffix] = true
+	}
+}
+
+func IsBreachedPassword(plaintext string) bool {
+	breachedOnce.Do(loadBreachedPasswords)
+
+	sum := sha1.Sum([]byte(plaintext))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	return breachedSuffixes[hash[:5]][hash[5:]]
+}
@@ -0,0 +1,144 @@
+package data
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
+)
+
+func TestPasswordPolicyRules(t *testing.T) {
+	strict := PasswordPolicy{
+		MinLength:     12,
+		RequireUpper:  true,
+		RequireLower:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+		MaxRepeated:   2,
+	}
+
+	tests := []struct {
+		name      string
+		policy    PasswordPolicy
+		plaintext string
+		want      []string // les messages attendus, aucun si vide
+	}{
+		{"valid", strict, "Correct-Horse-9", nil},
+		{"empty", strict, "", []string{
+			"must be provided",
+			"at least 12 bytes long",
+			"uppercase letter",
+			"lowercase letter",
+			"one digit",
+			"one symbol",
+		}},
+		{"too long", strict, strings.Repeat("Ab1-", 19), []string{"must be at most 72 bytes long"}},
+		{"min length", strict, "Abc-1234", []string{"must be at least 12 bytes long"}},
+		{"upper", strict, "correct-horse-9", []string{"uppercase letter"}},
+		{"lower", strict, "CORRECT-HORSE-9", []string{"lowercase letter"}},
+		{"digit", strict, "Correct-Horse-x", []string{"one digit"}},
+		{"symbol", strict, "CorrectHorse99", []string{"one symbol"}},
+		{"repeated", strict, "Correct-Horsss-9", []string{"more than 2 times in a row"}},
+		{"no repeat limit", PasswordPolicy{MinLength: 8}, "aaaaaaaaaa", nil},
+		{"every failed rule", strict, "aaaa", []string{
+			"at least 8 bytes long",
+			"at least 12 bytes long",
+			"uppercase letter",
+			"one digit",
+			"one symbol",
+			"more than 2 times in a row",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			tt.policy.Validate(v, tt.plaintext, nil)
+
+			message, failed := v.Errors["password"]
+			if len(tt.want) == 0 {
+				if failed {
+					t.Errorf("unexpected error %q", message)
+				}
+				return
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(message, want) {
+					t.Errorf("error %q does not contain %q", message, want)
+				}
+			}
+			if got := len(strings.Split(message, "; ")); got != len(tt.want) {
+				t.Errorf("error %q has %d messages, want %d", message, got, len(tt.want))
+			}
+		})
+	}
+}
+
+// Le message de ValidatePasswordPlainText deja ajoute est remplace par la liste complete
+func TestPasswordPolicyReplacesPlainTextError(t *testing.T) {
+	v := validator.New()
+	ValidatePasswordPlainText(v, "short")
+	PasswordPolicy{MinLength: 8, RequireDigit: true}.Validate(v, "short", nil)
+
+	want := "must be at least 8 bytes long; must contain at least one digit"
+	if v.Errors["password"] != want {
+		t.Errorf("got %q, want %q", v.Errors["password"], want)
+	}
+}
+
+func TestPasswordPolicyPersonalInfo(t *testing.T) {
+	user := &User{Name: "Alice Martin", Email: "amartin@example.com"}
+
+	tests := []struct {
+		plaintext string
+		refused   bool
+	}{
+		{"alice-rocks-2024", true},
+		{"MARTIN!secret", true},
+		{"hello-amartin-1", true},
+		{"al-is-too-short", false},
+		{"example-dot-com", false},
+		{"unrelated-words", false},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+		PasswordPolicy{MinLength: 8}.Validate(v, tt.plaintext, user)
+
+		refused := strings.Contains(v.Errors["password"], "your name or email address")
+		if refused != tt.refused {
+			t.Errorf("%q: refused = %v, want %v", tt.plaintext, refused, tt.refused)
+		}
+	}
+
+	v := validator.New()
+	PasswordPolicy{MinLength: 8}.Validate(v, "alice-rocks-2024", nil)
+	if !v.Valid() {
+		t.Errorf("without a user, got %q", v.Errors["password"])
+	}
+}
+
+func TestIsBreachedPassword(t *testing.T) {
+	tests := []struct {
+		plaintext string
+		breached  bool
+	}{
+		{"password", true},
+		{"password1", true},
+		{"Correct-Horse-9", false},
+		{"Tr0ub4dor&3xyz!", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsBreachedPassword(tt.plaintext); got != tt.breached {
+			t.Errorf("IsBreachedPassword(%q) = %v, want %v", tt.plaintext, got, tt.breached)
+		}
+	}
+
+	v := validator.New()
+	PasswordPolicy{MinLength: 8, CheckBreached: true}.Validate(v, "password", nil)
+	if !strings.Contains(v.Errors["password"], "breached passwords") {
+		t.Errorf("got %q, want the breached password message", v.Errors["password"])
+	}
+}
@@ -0,0 +1,162 @@
+package data
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Des parametres faibles, pour que les tests restent rapides
+var testArgon2id = Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1}
+
+func useHasher(t *testing.T, hasher PasswordHasher) {
+	t.Helper()
+
+	previous := currentHasher
+	SetPasswordHasher(hasher)
+	t.Cleanup(func() { SetPasswordHasher(previous) })
+}
+
+func TestArgon2idPHCRoundTrip(t *testing.T) {
+	hash, err := testArgon2id.Hash("pa55word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := fmt.Sprintf("$argon2id$v=%d$m=64,t=1,p=1$", argon2.Version)
+	if !bytes.HasPrefix(hash, []byte(prefix)) {
+		t.Errorf("hash %q does not start with %q", hash, prefix)
+	}
+
+	if !testArgon2id.Identifies(hash) || (BcryptHasher{}).Identifies(hash) {
+		t.Errorf("hash %q identified by the wrong hasher", hash)
+	}
+
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		t.Fatalf("decodeArgon2id: %v", err)
+	}
+	if params != testArgon2id || len(salt) != argon2SaltLength || len(key) != argon2KeyLength {
+		t.Errorf("decoded params %+v, salt %d bytes, key %d bytes", params, len(salt), len(key))
+	}
+
+	for password, want := range map[string]bool{"pa55word": true, "pa55word ": false, "": false} {
+		matched, err := testArgon2id.Verify(hash, password)
+		if err != nil || matched != want {
+			t.Errorf("Verify(%q) = (%v, %v), want (%v, nil)", password, matched, err, want)
+		}
+	}
+
+	other, err := testArgon2id.Hash("pa55word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(hash, other) {
+		t.Error("two hashes of the same password share their salt")
+	}
+}
+
+func TestArgon2idRejectsMalformedHashes(t *testing.T) {
+	hashes := []string{
+		"",
+		"$argon2id$",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ",
+		"$argon2id$v=18$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5",
+		"$argon2id$v=19$m=64;t=1;p=1$c2FsdHNhbHQ$a2V5a2V5",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHQ$a2V5a2V5",
+		"$argon2id$v=19$m=64,t=1,p=0$c2FsdHNhbHQ$a2V5a2V5",
+		"$argon2id$v=19$m=4,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$$a2V5a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$***",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5$extra",
+	}
+
+	for _, hash := range hashes {
+		matched, err := testArgon2id.Verify([]byte(hash), "pa55word")
+		if matched || !errors.Is(err, ErrUnknownPasswordHash) {
+			t.Errorf("Verify(%q) = (%v, %v), want (false, ErrUnknownPasswordHash)", hash, matched, err)
+		}
+
+		if !testArgon2id.NeedsRehash([]byte(hash)) {
+			t.Errorf("NeedsRehash(%q) = false", hash)
+		}
+	}
+}
+
+func TestArgon2idNeedsRehashOnParamChange(t *testing.T) {
+	hash, err := testArgon2id.Hash("pa55word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if testArgon2id.NeedsRehash(hash) {
+		t.Error("NeedsRehash with the same params = true")
+	}
+
+	changed := []Argon2idHasher{
+		{Memory: 128, Iterations: 1, Parallelism: 1},
+		{Memory: 64, Iterations: 2, Parallelism: 1},
+		{Memory: 64, Iterations: 1, Parallelism: 2},
+	}
+	for _, hasher := range changed {
+		if !hasher.NeedsRehash(hash) {
+			t.Errorf("NeedsRehash with %+v = false", hasher)
+		}
+	}
+}
+
+func TestPasswordMatchesAndOutdated(t *testing.T) {
+	useHasher(t, testArgon2id)
+
+	var p password
+	if err := p.Set("pa55word"); err != nil {
+		t.Fatal(err)
+	}
+
+	matched, err := p.Matches("wrong")
+	if err != nil || matched {
+		t.Errorf("Matches(wrong) = (%v, %v), want (false, nil)", matched, err)
+	}
+
+	matched, err = p.Matches("pa55word")
+	if err != nil || !matched {
+		t.Fatalf("Matches = (%v, %v), want (true, nil)", matched, err)
+	}
+	if p.Outdated() {
+		t.Error("Outdated with the current hasher and params = true")
+	}
+
+	// Les parametres changent: le hash reste valide mais doit etre recalcule
+	useHasher(t, Argon2idHasher{Memory: 128, Iterations: 1, Parallelism: 1})
+	matched, err = p.Matches("pa55word")
+	if err != nil || !matched || !p.Outdated() {
+		t.Errorf("after a param change Matches = (%v, %v), Outdated = %v", matched, err, p.Outdated())
+	}
+
+	// L'algorithme change: l'ancien hash argon2id reste verifiable
+	useHasher(t, BcryptHasher{Cost: 4})
+	matched, err = p.Matches("pa55word")
+	if err != nil || !matched || !p.Outdated() {
+		t.Errorf("after an algorithm change Matches = (%v, %v), Outdated = %v", matched, err, p.Outdated())
+	}
+
+	if err := p.Set("pa55word"); err != nil {
+		t.Fatal(err)
+	}
+	if !(BcryptHasher{}).Identifies(p.hash) || p.Outdated() {
+		t.Errorf("Set did not rehash with bcrypt: %q, outdated %v", p.hash, p.Outdated())
+	}
+}
+
+func TestPasswordMatchesUnknownHash(t *testing.T) {
+	p := password{hash: []byte("$plain$pa55word")}
+
+	matched, err := p.Matches("pa55word")
+	if matched || !errors.Is(err, ErrUnknownPasswordHash) {
+		t.Errorf("Matches = (%v, %v), want (false, ErrUnknownPasswordHash)", matched, err)
+	}
+}
@@ -0,0 +1,316 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
+	"github.com/lib/pq"
+)
+
+var ErrDuplicateCredit = errors.New("duplicate credit")
+
+const (
+	CreditRoleDirector = "director"
+	CreditRoleWriter   = "writer"
+	CreditRoleActor    = "actor"
+)
+
+type Person struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	BirthYear int32     `json:"birth_year,omitempty"`
+	Biography string    `json:"biography,omitempty"`
+	CreatedAt time.Time `json:"-"`
+	Version   int32     `json:"version"`
+}
+
+// Le role d'une personne dans un film
+type Credit struct {
+	ID           int64  `json:"id"`
+	MovieID      int64  `json:"movie_id"`
+	PersonID     int64  `json:"person_id"`
+	PersonName   string `json:"person_name"`
+	Role         string `json:"role"`
+	Character    string `json:"character,omitempty"`
+	BillingOrder int    `json:"billing_order"`
+}
+
+type PersonModel struct {
+	DB DBTX
+}
+
+type CreditModel struct {
+	DB DBTX
+}
+
+func ValidatePerson(v *validator.Validator, person *Person) {
+	v.Check(person.Name != "", "name", "must be provided")
+	v.Check(len(person.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	if person.BirthYear != 0 {
+		v.Check(person.BirthYear > 1800, "birth_year", "must be greater than 1800")
+		v.Check(person.BirthYear <= int32(time.Now().Year()), "birth_year", "must not be in the future")
+	}
+
+	v.Check(len(person.Biography) <= 10_000, "biography", "must not be more than 10000 bytes long")
+}
+
+func ValidateCredit(v *validator.Validator, credit *Credit) {
+	v.Check(credit.PersonID > 0, "person_id", "must be provided")
+
+	v.Check(validator.In(credit.Role, CreditRoleDirector, CreditRoleWriter, CreditRoleActor), "role", "must be one of director, writer or actor")
+	v.Check(credit.Role == CreditRoleActor || credit.Character == "", "character", "must only be provided for actors")
+	v.Check(len(credit.Character) <= 500, "character", "must not be more than 500 bytes long")
+
+	v.Check(credit.BillingOrder >= 0, "billing_order", "must not be negative")
+}
+
+func (m *PersonModel) Insert(person *Person) error {
+	query := `
+		INSERT INTO people (name, birth_year, biography)
+		VALUES ($1, NULLIF($2, 0), $3)
+		RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{person.Name, person.BirthYear, person.Biography}
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&person.ID, &person.CreatedAt, &person.Version)
+}
+
+func (m *PersonModel) Get(id int64) (*Person, error) {
+	query := `
+		SELECT id, name, COALESCE(birth_year, 0), biography, created_at, version
+		FROM people
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var person Person
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&person.ID,
+		&person.Name,
+		&person.BirthYear,
+		&person.Biography,
+		&person.CreatedAt,
+		&person.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &person, nil
+}
+
+func (m *PersonModel) GetAll(name string, filters Filters) ([]*Person, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, name, COALESCE(birth_year, 0), biography, created_at, version
+		FROM people
+		WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, name, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	people := []*Person{}
+	totalRecords := 0
+	for rows.Next() {
+		var person Person
+
+		err := rows.Scan(
+			&totalRecords,
+			&person.ID,
+			&person.Name,
+			&person.BirthYear,
+			&person.Biography,
+			&person.CreatedAt,
+			&person.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		people = append(people, &person)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return people, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func (m *PersonModel) Update(person *Person) error {
+	query := `
+		UPDATE people
+		SET name = $1, birth_year = NULLIF($2, 0), biography = $3, version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{person.Name, person.BirthYear, person.Biography, person.ID, person.Version}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&person.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Les credits de la personne sont supprimes en cascade
+func (m *PersonModel) Delete(id int64) error {
+	query := `
+		DELETE FROM people
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m *CreditModel) Insert(credit *Credit) error {
+	query := `
+		WITH inserted as (
+			INSERT INTO movie_credits (movie_id, person_id, role, character, billing_order)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id, person_id
+		)
+		SELECT i.id, p.name
+		FROM inserted as i
+		INNER JOIN people as p
+		ON p.id = i.person_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{credit.MovieID, credit.PersonID, credit.Role, credit.Character, credit.BillingOrder}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&credit.ID, &credit.PersonName)
+	if err != nil {
+		pqErr, ok := err.(*pq.Error)
+		if ok {
+			switch {
+			case pqErr.Code.Name() == "unique_violation" && pqErr.Constraint == "movie_credits_movie_id_person_id_role_character_key":
+				return ErrDuplicateCredit
+			case pqErr.Code.Name() == "foreign_key_violation":
+				return ErrRecordNotFound
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Realisateurs, puis scenaristes, puis acteurs dans l'ordre d'affiche
+func (m *CreditModel) GetAllForMovie(movieID int64) ([]*Credit, error) {
+	query := `
+		SELECT c.id, c.movie_id, c.person_id, p.name, c.role, c.character, c.billing_order
+		FROM movie_credits as c
+		INNER JOIN people as p
+		ON p.id = c.person_id
+		WHERE c.movie_id = $1
+		ORDER BY array_position(ARRAY['director', 'writer', 'actor'], c.role), c.billing_order, c.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := []*Credit{}
+	for rows.Next() {
+		var credit Credit
+
+		err := rows.Scan(
+			&credit.ID,
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.PersonName,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credits = append(credits, &credit)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+func (m *CreditModel) Delete(id int64, movieID int64) error {
+	query := `
+		DELETE FROM movie_credits
+		WHERE id = $1
+		AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
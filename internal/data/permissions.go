@@ -2,7 +2,6 @@ package data
 
 import (
 	"context"
-	"database/sql"
 	"time"
 
 	"github.com/lib/pq"
@@ -12,7 +11,8 @@ import (
 type Permissions []string
 
 type PermissionModel struct {
-	DB *sql.DB
+	DB DBTX
+	Cache *PermissionCache
 }
 
 func (p Permissions) Include(code string) bool {
@@ -28,6 +28,7 @@ func (p *PermissionModel) AddForUser(userId int64, codes ...string) error {
 	var sql string = `
 		INSERT INTO users_permissions (user_id, permission_id) 
 		(SELECT $1, p.id FROM permissions as p WHERE p.code = ANY($2))
+		ON CONFLICT DO NOTHING
 		`
 
 	args := []interface{}{
@@ -43,11 +44,50 @@ func (p *PermissionModel) AddForUser(userId int64, codes ...string) error {
 		return err
 	}
 
+	p.Cache.Invalidate(userId)
+
+	return nil
+}
+
+func (p *PermissionModel) RemoveForUser(userId int64, codes ...string) error {
+	var sql string = `
+		DELETE FROM users_permissions as up
+		USING permissions as p
+		WHERE up.permission_id = p.id
+		AND up.user_id = $1
+		AND p.code = ANY($2)
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := p.DB.ExecContext(ctx, sql, userId, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+
+	p.Cache.Invalidate(userId)
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
 	return nil
 }
 
 func (p *PermissionModel) GetAllForUser(userId int64) (*Permissions,error) {
 
+	if cached, found := p.Cache.get(userId); found {
+		return &cached, nil
+	}
+	generation := p.Cache.currentGeneration()
+
+	// Les permissions donnees directement, plus celles de ses roles
 	var sql string = `
 		SELECT p.code
 		FROM permissions as p 
@@ -56,6 +96,15 @@ func (p *PermissionModel) GetAllForUser(userId int64) (*Permissions,error) {
 		INNER JOIN users as u 
 		ON up.user_id = u.id
 		WHERE u.id=$1
+		UNION
+		SELECT p.code
+		FROM permissions as p
+		INNER JOIN roles_permissions as rp
+		ON p.id = rp.permission_id
+		INNER JOIN users_roles as ur
+		ON rp.role_id = ur.role_id
+		WHERE ur.user_id=$1
+		ORDER BY code
 		`
 
 	args := []interface{}{
@@ -85,5 +134,41 @@ func (p *PermissionModel) GetAllForUser(userId int64) (*Permissions,error) {
 		return nil, err
 	}
 
+	p.Cache.set(userId, generation, permissions)
+
 	return &permissions, nil
 }
+
+// Toutes les permissions qui existent
+func (p *PermissionModel) GetAll() (Permissions, error) {
+	var sql string = `
+		SELECT code
+		FROM permissions
+		ORDER BY code
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := Permissions{}
+	for rows.Next() {
+		var permission string
+		err = rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
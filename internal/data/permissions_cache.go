@@ -0,0 +1,176 @@
+package data
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache en memoire des permissions de chaque utilisateur.
+// Un cache nil ne garde rien, tout va a la base de donnees.
+type PermissionCache struct {
+	ttl       time.Duration
+	mutex     sync.RWMutex
+	entries   map[int64]permissionCacheEntry
+	lastSweep time.Time
+
+	// Chaque invalidation prend la generation suivante: une lecture commencee
+	// avant l'invalidation de son utilisateur ne doit pas remettre les anciennes
+	// permissions en cache. invalidated est vide a chaque nettoyage, les
+	// lectures commencees avant swept sont alors ignorees.
+	generation  uint64
+	invalidated map[int64]uint64
+	swept       uint64
+
+	// Le cache d'une transaction (Models.WithTx) ne lit ni ne garde rien, ses
+	// invalidations sont appliquees au vrai cache apres le commit
+	inTx    bool
+	pending []int64
+
+	hits          atomic.Int64
+	misses        atomic.Int64
+	invalidations atomic.Int64
+}
+
+type permissionCacheEntry struct {
+	permissions Permissions
+	expiry      time.Time
+}
+
+// Les compteurs publies sur /v1/metrics
+type PermissionCacheStats struct {
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	Invalidations int64 `json:"invalidations"`
+	Entries       int   `json:"entries"`
+}
+
+func NewPermissionCache(ttl time.Duration) *PermissionCache {
+	if ttl <= 0 {
+		return nil
+	}
+
+	return &PermissionCache{
+		ttl:         ttl,
+		entries:     make(map[int64]permissionCacheEntry),
+		lastSweep:   time.Now(),
+		invalidated: make(map[int64]uint64),
+	}
+}
+
+func newTxPermissionCache() *PermissionCache {
+	return &PermissionCache{inTx: true}
+}
+
+func (c *PermissionCache) get(userID int64) (Permissions, bool) {
+	if c == nil || c.inTx {
+		return nil, false
+	}
+
+	c.mutex.RLock()
+	entry, found := c.entries[userID]
+	c.mutex.RUnlock()
+
+	if !found || time.Now().After(entry.expiry) {
+		c.misses.Add(1)
+		return nil, false
+	}
+
+	c.hits.Add(1)
+
+	// Une copie, pour que l'appelant ne modifie pas le cache
+	return append(Permissions{}, entry.permissions...), true
+}
+
+// La generation courante, a lire avant d'aller a la base de donnees
+func (c *PermissionCache) currentGeneration() uint64 {
+	if c == nil {
+		return 0
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.generation
+}
+
+// Garde les permissions lues pendant la generation gen, sauf si une invalidation
+// est arrivee entre temps
+func (c *PermissionCache) set(userID int64, gen uint64, permissions Permissions) {
+	if c == nil || c.inTx {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	c.sweep(now)
+
+	if gen < c.swept || c.invalidated[userID] > gen {
+		return
+	}
+
+	c.entries[userID] = permissionCacheEntry{
+		permissions: append(Permissions{}, permissions...),
+		expiry:      now.Add(c.ttl),
+	}
+}
+
+// Nettoie les entrees expirees et les invalidations, au plus une fois par ttl.
+// Le mutex doit etre pris en ecriture.
+func (c *PermissionCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) <= c.ttl {
+		return
+	}
+
+	for id, entry := range c.entries {
+		if now.After(entry.expiry) {
+			delete(c.entries, id)
+		}
+	}
+
+	c.invalidated = make(map[int64]uint64)
+	c.swept = c.generation
+	c.lastSweep = now
+}
+
+// Invalidate drops the cached permissions of one user, after a grant or a revoke
+func (c *PermissionCache) Invalidate(userID int64) {
+	if c == nil {
+		return
+	}
+
+	if c.inTx {
+		c.mutex.Lock()
+		c.pending = append(c.pending, userID)
+		c.mutex.Unlock()
+		return
+	}
+
+	c.mutex.Lock()
+	c.sweep(time.Now())
+	delete(c.entries, userID)
+	c.generation++
+	c.invalidated[userID] = c.generation
+	c.mutex.Unlock()
+
+	c.invalidations.Add(1)
+}
+
+func (c *PermissionCache) Stats() PermissionCacheStats {
+	if c == nil {
+		return PermissionCacheStats{}
+	}
+
+	c.mutex.RLock()
+	entries := len(c.entries)
+	c.mutex.RUnlock()
+
+	return PermissionCacheStats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Invalidations: c.invalidations.Load(),
+		Entries:       entries,
+	}
+}
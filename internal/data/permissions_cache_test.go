@@ -0,0 +1,46 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPermissionCacheIgnoresReadStartedBeforeInvalidation(t *testing.T) {
+	c := NewPermissionCache(time.Minute)
+
+	gen := c.currentGeneration()
+	c.Invalidate(1)
+	c.set(1, gen, Permissions{"movies:write"})
+
+	if _, found := c.get(1); found {
+		t.Error("permissions read before the invalidation were cached")
+	}
+
+	c.set(1, c.currentGeneration(), Permissions{"movies:read"})
+
+	permissions, found := c.get(1)
+	if !found || !permissions.Include("movies:read") {
+		t.Errorf("get(1) = %v, %v", permissions, found)
+	}
+}
+
+func TestPermissionCacheSweepForgetsInvalidations(t *testing.T) {
+	c := NewPermissionCache(time.Minute)
+
+	gen := c.currentGeneration()
+	for id := int64(1); id <= 100; id++ {
+		c.Invalidate(id)
+	}
+
+	c.lastSweep = time.Now().Add(-2 * time.Minute)
+	c.set(1, gen, Permissions{"movies:write"})
+
+	if len(c.invalidated) != 0 {
+		t.Errorf("%d invalidations kept after the sweep", len(c.invalidated))
+	}
+
+	// La lecture a commence avant les invalidations oubliees
+	if _, found := c.get(1); found {
+		t.Error("permissions read before a swept invalidation were cached")
+	}
+}
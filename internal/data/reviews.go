@@ -0,0 +1,235 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
+	"github.com/lib/pq"
+)
+
+var ErrDuplicateReview = errors.New("duplicate review")
+
+type Review struct {
+	ID        int64     `json:"id"`
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"user_id"`
+	UserName  string    `json:"user_name"`
+	Rating    int       `json:"rating"` // de 1 a 10
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int32     `json:"version"`
+}
+
+type ReviewModel struct {
+	DB DBTX
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating >= 1, "rating", "must be at least 1")
+	v.Check(review.Rating <= 10, "rating", "must not be more than 10")
+
+	v.Check(len(review.Body) <= 10_000, "body", "must not be more than 10000 bytes long")
+}
+
+func (m *ReviewModel) Insert(review *Review) error {
+	query := `
+		INSERT INTO reviews (movie_id, user_id, rating, body)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{review.MovieID, review.UserID, review.Rating, review.Body}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.UpdatedAt, &review.Version)
+	if err != nil {
+		pqErr, ok := err.(*pq.Error)
+		if ok {
+			switch {
+			case pqErr.Code.Name() == "unique_violation" && pqErr.Constraint == "reviews_movie_id_user_id_key":
+				return ErrDuplicateReview
+			case pqErr.Code.Name() == "foreign_key_violation":
+				return ErrRecordNotFound
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Une critique n'est trouvee que sous son film
+func (m *ReviewModel) Get(id int64, movieID int64) (*Review, error) {
+	query := `
+		SELECT r.id, r.movie_id, r.user_id, u.name, r.rating, r.body, r.created_at, r.updated_at, r.version
+		FROM reviews as r
+		INNER JOIN users as u
+		ON u.id = r.user_id
+		WHERE r.id = $1
+		AND r.movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var review Review
+	err := m.DB.QueryRowContext(ctx, query, id, movieID).Scan(
+		&review.ID,
+		&review.MovieID,
+		&review.UserID,
+		&review.UserName,
+		&review.Rating,
+		&review.Body,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+func (m *ReviewModel) GetAllForMovie(movieID int64, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), r.id, r.movie_id, r.user_id, u.name, r.rating, r.body, r.created_at, r.updated_at, r.version
+		FROM reviews as r
+		INNER JOIN users as u
+		ON u.id = r.user_id
+		WHERE r.movie_id = $1
+		ORDER BY r.%s %s, r.id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	reviews, totalRecords, err := scanReviews(rows)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return reviews, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Toutes les critiques d'un utilisateur, pour l'export de ses donnees
+func (m *ReviewModel) GetAllForUser(userID int64) ([]*Review, error) {
+	query := `
+		SELECT COUNT(*) OVER(), r.id, r.movie_id, r.user_id, u.name, r.rating, r.body, r.created_at, r.updated_at, r.version
+		FROM reviews as r
+		INNER JOIN users as u
+		ON u.id = r.user_id
+		WHERE r.user_id = $1
+		ORDER BY r.created_at, r.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews, _, err := scanReviews(rows)
+	return reviews, err
+}
+
+func scanReviews(rows *sql.Rows) ([]*Review, int, error) {
+	reviews := []*Review{}
+	totalRecords := 0
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.MovieID,
+			&review.UserID,
+			&review.UserName,
+			&review.Rating,
+			&review.Body,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return reviews, totalRecords, nil
+}
+
+func (m *ReviewModel) Update(review *Review) error {
+	query := `
+		UPDATE reviews
+		SET rating = $1, body = $2, updated_at = now(), version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING updated_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{review.Rating, review.Body, review.ID, review.Version}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&review.UpdatedAt, &review.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *ReviewModel) Delete(id int64, movieID int64) error {
+	query := `
+		DELETE FROM reviews
+		WHERE id = $1
+		AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// L'etat complet d'un film a une version donnee
+type MovieRevision struct {
+	MovieID   int64     `json:"movie_id"`
+	Version   int32     `json:"version"`
+	Title     string    `json:"title"`
+	Year      int32     `json:"year"`
+	Runtime   Runtime   `json:"runtime"`
+	Genres    []string  `json:"genres"`
+	ChangedBy *int64    `json:"changed_by"` // nil si inconnu ou supprime, jamais son nom
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+type MovieRevisionModel struct {
+	DB DBTX
+}
+
+// Enregistre l'etat actuel du film, dans la transaction qui l'a modifie
+func insertMovieRevision(ctx context.Context, tx DBTX, movie *Movie, userID int64) error {
+	query := `
+		INSERT INTO movie_revisions (movie_id, version, title, year, runtime, genres, changed_by)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, 0))`
+
+	args := []interface{}{
+		movie.ID,
+		movie.Version,
+		movie.Title,
+		movie.Year,
+		movie.Runtime,
+		pq.Array(movie.Genres),
+		userID,
+	}
+
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (m *MovieRevisionModel) Get(movieID int64, version int32) (*MovieRevision, error) {
+	query := `
+		SELECT r.movie_id, r.version, r.title, r.year, r.runtime, r.genres, r.changed_by, r.changed_at
+		FROM movie_revisions as r
+		WHERE r.movie_id = $1
+		AND r.version = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var revision MovieRevision
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(
+		&revision.MovieID,
+		&revision.Version,
+		&revision.Title,
+		&revision.Year,
+		&revision.Runtime,
+		pq.Array(&revision.Genres),
+		&revision.ChangedBy,
+		&revision.ChangedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &revision, nil
+}
+
+func (m *MovieRevisionModel) GetAllForMovie(movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), r.movie_id, r.version, r.title, r.year, r.runtime, r.genres, r.changed_by, r.changed_at
+		FROM movie_revisions as r
+		WHERE r.movie_id = $1
+		ORDER BY r.%s %s
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	revisions := []*MovieRevision{}
+	totalRecords := 0
+	for rows.Next() {
+		var revision MovieRevision
+
+		err := rows.Scan(
+			&totalRecords,
+			&revision.MovieID,
+			&revision.Version,
+			&revision.Title,
+			&revision.Year,
+			&revision.Runtime,
+			pq.Array(&revision.Genres),
+			&revision.ChangedBy,
+			&revision.ChangedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		revisions = append(revisions, &revision)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return revisions, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
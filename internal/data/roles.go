@@ -0,0 +1,222 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
+	"github.com/lib/pq"
+)
+
+var RoleNameRX = regexp.MustCompile("^[a-z][a-z0-9-]*$")
+
+var ErrDuplicateRoleName = errors.New("duplicate role name")
+
+// Un role regroupe plusieurs codes de permission
+type Role struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Permissions []string  `json:"permissions"`
+}
+
+type RoleModel struct {
+	DB DBTX
+	// Les permissions d'un utilisateur changent avec ses roles
+	PermissionCache *PermissionCache
+}
+
+func ValidateRole(v *validator.Validator, role *Role, knownPermissions Permissions) {
+	v.Check(role.Name != "", "name", "must be provided")
+	v.Check(len(role.Name) <= 50, "name", "must not be more than 50 bytes long")
+	v.Check(validator.Matches(role.Name, RoleNameRX), "name", "must contain only lowercase letters, digits and dashes")
+
+	v.Check(len(role.Description) <= 500, "description", "must not be more than 500 bytes long")
+
+	v.Check(len(role.Permissions) > 0, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(role.Permissions), "permissions", "must not contain duplicate values")
+	for _, code := range role.Permissions {
+		v.Check(knownPermissions.Include(code), "permissions", "must only contain existing permission codes")
+	}
+}
+
+func (m *RoleModel) Insert(role *Role) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO roles (name, description)
+		VALUES ($1, $2)
+		RETURNING id, created_at`
+
+	err = tx.QueryRowContext(ctx, query, role.Name, role.Description).Scan(&role.ID, &role.CreatedAt)
+	if err != nil {
+		pqErr, ok := err.(*pq.Error)
+		if ok && pqErr.Code.Name() == "unique_violation" && pqErr.Constraint == "roles_name_key" {
+			return ErrDuplicateRoleName
+		}
+		return err
+	}
+
+	query = `
+		INSERT INTO roles_permissions (role_id, permission_id)
+		(SELECT $1, p.id FROM permissions as p WHERE p.code = ANY($2))`
+
+	_, err = tx.ExecContext(ctx, query, role.ID, pq.Array(role.Permissions))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m *RoleModel) GetAll() ([]*Role, error) {
+	query := `
+		SELECT r.id, r.created_at, r.name, r.description,
+			COALESCE(array_agg(p.code ORDER BY p.code) FILTER (WHERE p.code IS NOT NULL), '{}')
+		FROM roles as r
+		LEFT JOIN roles_permissions as rp
+		ON r.id = rp.role_id
+		LEFT JOIN permissions as p
+		ON p.id = rp.permission_id
+		GROUP BY r.id
+		ORDER BY r.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRoles(rows)
+}
+
+func (m *RoleModel) GetAllForUser(userID int64) ([]*Role, error) {
+	query := `
+		SELECT r.id, r.created_at, r.name, r.description,
+			COALESCE(array_agg(p.code ORDER BY p.code) FILTER (WHERE p.code IS NOT NULL), '{}')
+		FROM roles as r
+		INNER JOIN users_roles as ur
+		ON r.id = ur.role_id
+		LEFT JOIN roles_permissions as rp
+		ON r.id = rp.role_id
+		LEFT JOIN permissions as p
+		ON p.id = rp.permission_id
+		WHERE ur.user_id = $1
+		GROUP BY r.id
+		ORDER BY r.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRoles(rows)
+}
+
+func scanRoles(rows *sql.Rows) ([]*Role, error) {
+	roles := []*Role{}
+	for rows.Next() {
+		var role Role
+
+		err := rows.Scan(
+			&role.ID,
+			&role.CreatedAt,
+			&role.Name,
+			&role.Description,
+			pq.Array(&role.Permissions),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, &role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// Donne un role a un utilisateur. Le donner une deuxieme fois ne fait rien.
+func (m *RoleModel) AssignToUser(userID int64, roleName string) error {
+	query := `
+		INSERT INTO users_roles (user_id, role_id)
+		SELECT $1, r.id FROM roles as r WHERE r.name = $2
+		ON CONFLICT DO NOTHING
+		RETURNING role_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var roleID int64
+	err := m.DB.QueryRowContext(ctx, query, userID, roleName).Scan(&roleID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	// Aucune ligne: soit le role n'existe pas, soit l'utilisateur l'a deja
+	if errors.Is(err, sql.ErrNoRows) {
+		var exists bool
+		err = m.DB.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM roles WHERE name = $1)`, roleName).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrRecordNotFound
+		}
+	}
+
+	m.PermissionCache.Invalidate(userID)
+
+	return nil
+}
+
+func (m *RoleModel) RemoveFromUser(userID int64, roleName string) error {
+	query := `
+		DELETE FROM users_roles as ur
+		USING roles as r
+		WHERE ur.role_id = r.id
+		AND ur.user_id = $1
+		AND r.name = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, roleName)
+	if err != nil {
+		return err
+	}
+
+	m.PermissionCache.Invalidate(userID)
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
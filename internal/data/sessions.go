@@ -0,0 +1,160 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Une session est une famille de tokens vue par l'utilisateur: elle commence au
+// login et survit aux rotations. Son id, sa date et son client sont ceux du
+// premier refresh token de la famille. Le hash des tokens n'est jamais expose.
+type Session struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	Current    bool       `json:"current"`
+}
+
+// Records when and from where a token was last used. The row is only written
+// again after a minute, unless the client changed.
+func (t *TokenModel) Touch(tokenPlaintext string, userAgent string, ip string) error {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	var query string = `
+		UPDATE tokens
+		SET last_used_at = now(), user_agent = $2, ip = $3
+		WHERE hash = $1
+		AND (last_used_at IS NULL
+			OR last_used_at < now() - INTERVAL '1 minute'
+			OR user_agent <> $2
+			OR ip <> $3)
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := t.DB.ExecContext(ctx, query, hash[:], userAgent, ip)
+	return err
+}
+
+// currentFamily est la famille du token de la requete, nil si aucune
+func (t *TokenModel) GetAllSessionsForUser(userID int64, currentFamily []byte) ([]*Session, error) {
+	var query string = `
+		SELECT first.id, first.created_at, s.last_used_at, s.expiry, first.user_agent, first.ip,
+			COALESCE(first.family = $2, false)
+		FROM (
+			SELECT family,
+				MIN(id) FILTER (WHERE scope = $3) as first_id,
+				MAX(GREATEST(last_used_at, used_at)) as last_used_at,
+				MAX(expiry) FILTER (WHERE scope = $3 AND used_at IS NULL) as expiry
+			FROM tokens
+			WHERE user_id = $1
+			AND family IS NOT NULL
+			GROUP BY family
+		) as s
+		INNER JOIN tokens as first
+		ON first.id = s.first_id
+		WHERE s.expiry > now()
+		ORDER BY first.created_at DESC, first.id DESC
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := t.DB.QueryContext(ctx, query, userID, currentFamily, ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+
+		err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.UserAgent,
+			&session.IP,
+			&session.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// La famille d'un token, c'est-a-dire sa session
+func (t *TokenModel) GetFamily(scope string, tokenPlaintext string) ([]byte, error) {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	var query string = `
+		SELECT family FROM tokens
+		WHERE hash = $1
+		AND scope = $2
+		AND family IS NOT NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var family []byte
+	err := t.DB.QueryRowContext(ctx, query, hash[:], scope).Scan(&family)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return family, nil
+}
+
+// Supprime une session, seulement si elle appartient a l'utilisateur.
+// Tous les tokens de la famille sont revoques avec elle.
+func (t *TokenModel) DeleteSessionForUser(id int64, userID int64) error {
+	var query string = `
+		DELETE FROM tokens
+		WHERE user_id = $2
+		AND family = (
+			SELECT family FROM tokens
+			WHERE id = $1 AND user_id = $2 AND scope = $3
+		)
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := t.DB.ExecContext(ctx, query, id, userID, ScopeRefresh)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
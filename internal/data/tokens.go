@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"math"
 	"time"
 
@@ -15,6 +16,11 @@ import (
 const (
 	ScopeActivation = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset = "password-reset"
+	ScopeRefresh = "refresh"
+	ScopeUnlock = "unlock"
+	ScopeTwoFactorChallenge = "2fa-challenge"
+	ScopeEmailChange = "email-change"
 )
 
 const(
@@ -28,10 +34,14 @@ type Token struct {
 	UserID    int64	 `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string `json:"-"`
+	Family    []byte `json:"-"`
 }
 
+// Un refresh token deja consomme a ete presente une deuxieme fois
+var ErrTokenReused = errors.New("token reused")
+
 type TokenModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
 func ValidateToken(v *validator.Validator, tokenPlainText string) {
@@ -54,6 +64,55 @@ func (t *TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 
 }
 
+// Issues a short-lived authentication token together with a refresh token.
+// Both belong to the same family, a nil family starts a new one (a new login).
+func (t *TokenModel) NewPair(userID int64, authenticationTTL time.Duration, refreshTTL time.Duration, family []byte) (*Token, *Token, error) {
+	if family == nil {
+		var err error
+		family, err = NewTokenFamily()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	authenticationToken, err := t.NewInFamily(userID, authenticationTTL, ScopeAuthentication, family)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refreshToken, err := t.NewInFamily(userID, refreshTTL, ScopeRefresh, family)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return authenticationToken, refreshToken, nil
+}
+
+func (t *TokenModel) NewInFamily(userID int64, ttl time.Duration, scope string, family []byte) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	token.Family = family
+
+	err = t.Insert(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// Une nouvelle famille de tokens, a chaque login
+func NewTokenFamily() ([]byte, error) {
+	family := make([]byte, tokenLength)
+	_, err := rand.Read(family)
+	if err != nil {
+		return nil, err
+	}
+	return family, nil
+}
+
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
 	var token *Token = &Token{
 		UserID: userID,
@@ -79,14 +138,21 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 
 func (t* TokenModel) Insert(token *Token) error{
 	query := `
-		INSERT INTO tokens (hash, user_id, expiry, scope)
-		VALUES ($1, $2, $3, $4)`
+		INSERT INTO tokens (hash, user_id, expiry, scope, family)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	// Sans famille, la colonne reste NULL
+	var family interface{}
+	if token.Family != nil {
+		family = token.Family
+	}
 
 	args := []interface{}{
 		token.Hash,
 		token.UserID,
 		token.Expiry,
 		token.Scope,
+		family,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -118,6 +184,146 @@ func (t *TokenModel) DeleteAllTokensForUser(scope string, userID int64) error {
 	return nil
 }
 
+// Supprime tous les tokens d'un utilisateur, quelle que soit la scope
+func (t *TokenModel) DeleteAllForUser(userID int64) error {
+	var query string = `DELETE FROM tokens
+		WHERE user_id=$1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := t.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Supprime un token, par exemple celui utilise pour la requete courante,
+// ainsi que tous les tokens de sa famille
+func (t *TokenModel) Delete(scope string, tokenPlaintext string) error {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
 
+	var query string = `
+		WITH target AS (
+			SELECT hash, family FROM tokens
+			WHERE hash=$1 AND scope=$2
+		)
+		DELETE FROM tokens as t
+		USING target
+		WHERE t.hash = target.hash
+		OR (target.family IS NOT NULL AND t.family = target.family)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
+	result, err := t.DB.ExecContext(ctx, query, hash[:], scope)
+	if err != nil {
+		return err
+	}
 
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Consumes a refresh token. The token is only marked as used so that a second
+// presentation can be detected: in that case the whole family is revoked and
+// ErrTokenReused is returned. Used refresh tokens are kept as long as their
+// family, only the family's previous authentication token is deleted.
+func (t *TokenModel) Rotate(refreshTokenPlaintext string) (*Token, error) {
+	hash := sha256.Sum256([]byte(refreshTokenPlaintext))
+
+	var query string = `
+		UPDATE tokens
+		SET used_at = now()
+		WHERE hash = $1
+		AND scope = $2
+		AND expiry > now()
+		AND used_at IS NULL
+		RETURNING user_id, expiry, family`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var token Token = Token{
+		Plaintext: refreshTokenPlaintext,
+		Hash: hash[:],
+		Scope: ScopeRefresh,
+	}
+
+	tx, err := beginTx(ctx, t.DB)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, hash[:], ScopeRefresh).Scan(&token.UserID, &token.Expiry, &token.Family)
+	if err == nil {
+		query = `
+			DELETE FROM tokens
+			WHERE family = $1
+			AND scope = $2`
+
+		_, err = tx.ExecContext(ctx, query, token.Family, ScopeAuthentication)
+		if err != nil {
+			return nil, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return nil, err
+		}
+
+		return &token, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// Le token n'est pas utilisable, a-t-il deja ete consomme ?
+	query = `
+		SELECT family FROM tokens
+		WHERE hash = $1
+		AND scope = $2
+		AND used_at IS NOT NULL`
+
+	var family []byte
+	err = t.DB.QueryRowContext(ctx, query, hash[:], ScopeRefresh).Scan(&family)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = t.DeleteFamily(family)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, ErrTokenReused
+}
+
+func (t *TokenModel) DeleteFamily(family []byte) error {
+	var query string = `DELETE FROM tokens
+		WHERE family=$1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := t.DB.ExecContext(ctx, query, family)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
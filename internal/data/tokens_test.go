@@ -0,0 +1,124 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Un vieux refresh token rejoue apres plusieurs rotations revoque toujours la famille
+func TestTokenRotateDetectsReuseAfterSeveralRotations(t *testing.T) {
+	db := openTestDB(t)
+	m := TokenModel{DB: db}
+
+	userID := insertTestUser(t, db, "refresh-reuse@example.com")
+
+	_, first, err := m.NewPair(userID, time.Minute, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current := first
+	for i := 0; i < 2; i++ {
+		consumed, err := m.Rotate(current.Plaintext)
+		if err != nil {
+			t.Fatalf("rotation %d: %v", i+1, err)
+		}
+
+		_, current, err = m.NewPair(userID, time.Minute, time.Hour, consumed.Family)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := m.Rotate(first.Plaintext); !errors.Is(err, ErrTokenReused) {
+		t.Fatalf("Rotate(first) = %v, want ErrTokenReused", err)
+	}
+
+	// Toute la famille est revoquee, y compris le dernier refresh token
+	if _, err := m.Rotate(current.Plaintext); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("Rotate(current) = %v, want ErrRecordNotFound", err)
+	}
+}
+
+func TestTokenRotateDeletesPreviousAuthenticationToken(t *testing.T) {
+	db := openTestDB(t)
+	m := TokenModel{DB: db}
+
+	userID := insertTestUser(t, db, "refresh-rotate@example.com")
+
+	authenticationToken, refreshToken, err := m.NewPair(userID, time.Minute, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Rotate(refreshToken.Plaintext); err != nil {
+		t.Fatal(err)
+	}
+
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM tokens WHERE hash = $1`, authenticationToken.Hash).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("the previous authentication token was not deleted")
+	}
+}
+
+// Une session garde son id et sa date de debut a travers les rotations
+func TestSessionSurvivesRotation(t *testing.T) {
+	db := openTestDB(t)
+	m := TokenModel{DB: db}
+
+	userID := insertTestUser(t, db, "session-rotate@example.com")
+
+	_, refreshToken, err := m.NewPair(userID, time.Minute, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := m.GetAllSessionsForUser(userID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) != 1 {
+		t.Fatalf("got %d sessions, want 1", len(before))
+	}
+
+	consumed, err := m.Rotate(refreshToken.Plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = m.NewPair(userID, time.Minute, time.Hour, consumed.Family); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := m.GetAllSessionsForUser(userID, consumed.Family)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != 1 {
+		t.Fatalf("got %d sessions after rotation, want 1", len(after))
+	}
+
+	if after[0].ID != before[0].ID || !after[0].CreatedAt.Equal(before[0].CreatedAt) {
+		t.Errorf("session changed from %d (%v) to %d (%v)", before[0].ID, before[0].CreatedAt, after[0].ID, after[0].CreatedAt)
+	}
+	if !after[0].Current {
+		t.Error("the session of the current family is not marked as current")
+	}
+
+	if err = m.DeleteSessionForUser(after[0].ID, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := m.GetAllSessionsForUser(userID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 0 {
+		t.Errorf("got %d sessions after revocation, want 0", len(sessions))
+	}
+}
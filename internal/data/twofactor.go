@@ -0,0 +1,233 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
+)
+
+const recoveryCodeCount = 10
+
+var (
+	ErrTwoFactorAlreadyEnabled = errors.New("two factor authentication already enabled")
+	ErrTOTPCodeReused          = errors.New("totp code already used")
+)
+
+type TwoFactor struct {
+	UserID       int64
+	Secret       []byte
+	Confirmed    bool
+	LastUsedStep int64
+	CreatedAt    time.Time
+}
+
+type TwoFactorModel struct {
+	DB DBTX
+}
+
+func ValidateTOTPCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) == 6, "code", "must be 6 digits long")
+}
+
+// Les codes de recuperation ressemblent a "ABCD-EFGH"
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		randomBytes := make([]byte, 5)
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		code := base32.StdEncoding.EncodeToString(randomBytes)
+		codes[i] = code[:4] + "-" + code[4:]
+	}
+	return codes, nil
+}
+
+// Starts (or restarts) an enrollment. The secret stays unconfirmed until a
+// first code is verified, and the previous recovery codes are replaced.
+// Returns the new recovery codes in plaintext, they are never stored as such.
+func (m *TwoFactorModel) Enroll(userID int64, secret []byte) ([]string, error) {
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO users_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, created_at = now()
+		WHERE users_totp.confirmed = false`
+
+	result, err := tx.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	// La ligne existe deja et elle est confirmee
+	if rowsAffected == 0 {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, code := range codes {
+		hash := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, hash) VALUES ($1, $2)`, userID, hash[:])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (m *TwoFactorModel) Get(userID int64) (*TwoFactor, error) {
+	query := `
+		SELECT user_id, secret, confirmed, last_used_step, created_at
+		FROM users_totp
+		WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var twoFactor TwoFactor
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&twoFactor.UserID,
+		&twoFactor.Secret,
+		&twoFactor.Confirmed,
+		&twoFactor.LastUsedStep,
+		&twoFactor.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &twoFactor, nil
+}
+
+// La 2FA n'est active qu'une fois confirmee
+func (m *TwoFactorModel) IsEnabled(userID int64) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM users_totp WHERE user_id = $1 AND confirmed)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var enabled bool
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&enabled)
+	return enabled, err
+}
+
+// Marks a code as used and, for an enrollment, confirms the secret.
+// A step that is not newer than the last used one is refused (replay).
+func (m *TwoFactorModel) UseStep(userID int64, step int64) error {
+	query := `
+		UPDATE users_totp
+		SET last_used_step = $2, confirmed = true
+		WHERE user_id = $1
+		AND last_used_step < $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrTOTPCodeReused
+	}
+
+	return nil
+}
+
+// Un code de recuperation ne sert qu'une fois
+func (m *TwoFactorModel) UseRecoveryCode(userID int64, code string) error {
+	hash := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+
+	query := `
+		UPDATE recovery_codes
+		SET used_at = now()
+		WHERE user_id = $1
+		AND hash = $2
+		AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, hash[:])
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m *TwoFactorModel) Disable(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.ExecContext(ctx, `DELETE FROM users_totp WHERE user_id = $1`, userID)
+	return err
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
@@ -20,11 +21,12 @@ type User struct {
 	Email string `json:"email"`
 	Password password `json:"-"`
 	Activated bool `json:"activated"`
+	Locked bool `json:"locked"`
 	Version int `json:"-"`
 }
 
 type UserModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
 var ErrDuplicateEmail = errors.New("duplicate email")
@@ -67,7 +69,7 @@ func (u *UserModel)GetForToken(tokenScope string, tokenPlaintext string) (*User,
 	hash := sha256.Sum256([]byte(tokenPlaintext))
 	var user User
 	var query string = `
-		SELECT u.id, u.created_at, u.name, u.email, u.password_hash, u.activated, u.version
+		SELECT u.id, u.created_at, u.name, u.email, u.password_hash, u.activated, u.locked, u.version
 		FROM users as u 
 		INNER JOIN tokens as t 
 		ON u.id = t.user_id
@@ -90,6 +92,7 @@ func (u *UserModel)GetForToken(tokenScope string, tokenPlaintext string) (*User,
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Locked,
 		&user.Version,
 	)
 
@@ -105,10 +108,131 @@ func (u *UserModel)GetForToken(tokenScope string, tokenPlaintext string) (*User,
 	return &user, nil
 }
 
+// L'utilisateur d'un JWT, tant que sa famille de tokens n'a pas ete revoquee
+// (deconnexion, verrouillage, suppression du compte)
+func (u *UserModel) GetForTokenFamily(userID int64, family []byte) (*User, error) {
+	var user User
+	var query string = `
+		SELECT u.id, u.created_at, u.name, u.email, u.password_hash, u.activated, u.locked, u.version
+		FROM users as u
+		WHERE u.id = $1
+		AND EXISTS (
+			SELECT 1 FROM tokens as t
+			WHERE t.user_id = u.id
+			AND t.family = $2
+			AND t.scope = $3
+			AND t.expiry > now()
+		)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := u.DB.QueryRowContext(ctx, query, userID, family, ScopeRefresh).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Locked,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return nil, ErrRecordNotFound
+			default:
+				return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+func (u *UserModel) Get(id int64) (*User, error) {
+
+	query := `
+	SELECT id, created_at, name, email, password_hash, activated, locked, version
+	FROM users
+	WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var user User
+	err := u.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID, &user.CreatedAt,
+		&user.Name, &user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Locked,
+		&user.Version,)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// Recherche les utilisateurs par nom ou email, pour l'administration
+func (u *UserModel) GetAll(search string, filters Filters) ([]*User, Metadata, error) {
+
+	query := fmt.Sprintf(`
+	SELECT COUNT(*) OVER(), id, created_at, name, email, password_hash, activated, locked, version
+	FROM users
+	WHERE (name ILIKE '%%' || $1 || '%%' ESCAPE '\' OR email ILIKE '%%' || $1 || '%%' ESCAPE '\' OR $1 = '')
+	ORDER BY %s %s, id ASC
+	LIMIT $2 OFFSET $3
+	`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := u.DB.QueryContext(ctx, query, escapeLike(search), filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	var totalRecords int = 0
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID, &user.CreatedAt,
+			&user.Name, &user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Locked,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return users, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
 func (u *UserModel)GetByEmail(email string) (*User, error ) {
 
 	query := `
-	SELECT id, created_at, name, email, password_hash, activated, version
+	SELECT id, created_at, name, email, password_hash, activated, locked, version
 	FROM users
 	WHERE email = $1
 	`
@@ -122,6 +246,7 @@ func (u *UserModel)GetByEmail(email string) (*User, error ) {
 		&user.Name, &user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Locked,
 		&user.Version,)
 	if err != nil {
 		switch {
@@ -140,8 +265,8 @@ func (u *UserModel)Update(user *User) error {
 
 	query := `
 	UPDATE users
-	SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-	WHERE id = $5 AND version = $6 
+	SET name = $1, email = $2, password_hash = $3, activated = $4, locked = $5, version = version + 1
+	WHERE id = $6 AND version = $7 
 	RETURNING version
 	`
 
@@ -150,6 +275,7 @@ func (u *UserModel)Update(user *User) error {
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.Locked,
 		user.ID,
 		user.Version,
 	}
@@ -159,12 +285,13 @@ func (u *UserModel)Update(user *User) error {
 
 	err := u.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrEditConflict
+		}
 		pqErr, ok := err.(*pq.Error); if ok {
 			switch {
 			case pqErr.Code.Name() == "unique_violation" && pqErr.Constraint == "users_email_key":
 				return ErrDuplicateEmail
-			case errors.Is(err, sql.ErrNoRows):
-				return ErrEditConflict
 			}
 		}
 
@@ -174,6 +301,145 @@ func (u *UserModel)Update(user *User) error {
 	return nil
 }
 
+// Garde la nouvelle adresse en attente, jusqu'a ce que son proprietaire la confirme
+func (u *UserModel) SetPendingEmail(userID int64, email string) error {
+
+	query := `
+	UPDATE users
+	SET pending_email = $1
+	WHERE id = $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := u.DB.ExecContext(ctx, query, email, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Replaces the email of the user with the pending one. Returns
+// ErrRecordNotFound when there is no pending email, and ErrDuplicateEmail when
+// another account took the address in the meantime.
+func (u *UserModel) CommitPendingEmail(user *User) error {
+
+	query := `
+	UPDATE users
+	SET email = pending_email, pending_email = NULL, version = version + 1
+	WHERE id = $1 AND pending_email IS NOT NULL
+	RETURNING email, version
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := u.DB.QueryRowContext(ctx, query, user.ID).Scan(&user.Email, &user.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		pqErr, ok := err.(*pq.Error); if ok {
+			switch {
+			case pqErr.Code.Name() == "unique_violation" && pqErr.Constraint == "users_email_key":
+				return ErrDuplicateEmail
+			}
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Re-hashes the password with the current hasher. The row is only updated if
+// the hash did not change in the meantime; the version is left untouched
+// because nothing the user sees changes.
+func (u *UserModel) RehashPassword(user *User, plainTextPassword string) error {
+	oldHash := user.Password.hash
+
+	err := user.Password.Set(plainTextPassword)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	UPDATE users
+	SET password_hash = $1
+	WHERE id = $2 AND password_hash = $3
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = u.DB.ExecContext(ctx, query, user.Password.hash, user.ID, oldHash)
+	return err
+}
+
+// L'adresse en attente de confirmation, "" s'il n'y en a pas
+func (u *UserModel) GetPendingEmail(userID int64) (string, error) {
+
+	query := `
+	SELECT pending_email
+	FROM users
+	WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var pendingEmail sql.NullString
+	err := u.DB.QueryRowContext(ctx, query, userID).Scan(&pendingEmail)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	return pendingEmail.String, nil
+}
+
+// Les tokens, permissions, roles, cles API... sont supprimes par ON DELETE CASCADE
+func (u *UserModel) Delete(id int64) error {
+
+	query := `
+	DELETE FROM users
+	WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := u.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
 
 func ValidateEmail(v *validator.Validator, email string) {
 	//ユーザのメール
@@ -0,0 +1,335 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/VladimirArtyom/rest_eiga_api/internal/validator"
+	"github.com/lib/pq"
+)
+
+var ErrDuplicateWatchlistEntry = errors.New("movie already in the watchlist")
+
+type WatchlistEntry struct {
+	MovieID int64     `json:"movie_id"`
+	Title   string    `json:"title"`
+	Year    int32     `json:"year"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+type WatchedEntry struct {
+	ID        int64     `json:"id"`
+	MovieID   int64     `json:"movie_id"`
+	Title     string    `json:"title"`
+	Year      int32     `json:"year"`
+	WatchedOn time.Time `json:"watched_on"`
+	Rating    *int      `json:"rating"` // nil sans note
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WatchlistModel struct {
+	DB DBTX
+}
+
+type WatchedModel struct {
+	DB DBTX
+}
+
+func ValidateWatchedEntry(v *validator.Validator, entry *WatchedEntry) {
+	v.Check(entry.MovieID > 0, "movie_id", "must be provided")
+
+	v.Check(!entry.WatchedOn.IsZero(), "watched_on", "must be provided")
+	v.Check(entry.WatchedOn.Before(time.Now()), "watched_on", "must not be in the future")
+
+	if entry.Rating != nil {
+		v.Check(*entry.Rating >= 1, "rating", "must be at least 1")
+		v.Check(*entry.Rating <= 10, "rating", "must not be more than 10")
+	}
+}
+
+func (m *WatchlistModel) Add(userID int64, movieID int64) (*WatchlistEntry, error) {
+	query := `
+		WITH inserted as (
+			INSERT INTO watchlist (user_id, movie_id)
+			SELECT $1::bigint, id FROM movies
+			WHERE id = $2 AND deleted_at IS NULL
+			RETURNING movie_id, added_at
+		)
+		SELECT i.movie_id, m.title, m.year, i.added_at
+		FROM inserted as i
+		INNER JOIN movies as m
+		ON m.id = i.movie_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var entry WatchlistEntry
+	err := m.DB.QueryRowContext(ctx, query, userID, movieID).Scan(&entry.MovieID, &entry.Title, &entry.Year, &entry.AddedAt)
+	if err != nil {
+		// Le film n'existe pas ou il est a la corbeille
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		pqErr, ok := err.(*pq.Error)
+		if ok {
+			switch {
+			case pqErr.Code.Name() == "unique_violation" && pqErr.Constraint == "watchlist_pkey":
+				return nil, ErrDuplicateWatchlistEntry
+			case pqErr.Code.Name() == "foreign_key_violation":
+				return nil, ErrRecordNotFound
+			}
+		}
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+func (m *WatchlistModel) Remove(userID int64, movieID int64) error {
+	query := `
+		DELETE FROM watchlist
+		WHERE user_id = $1
+		AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m *WatchlistModel) GetAllForUser(userID int64, filters Filters) ([]*WatchlistEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), w.movie_id, m.title, m.year, w.added_at
+		FROM watchlist as w
+		INNER JOIN movies as m
+		ON m.id = w.movie_id
+		WHERE w.user_id = $1
+		AND m.deleted_at IS NULL
+		ORDER BY %s %s, w.movie_id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	entries, totalRecords, err := scanWatchlist(rows)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return entries, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Toute la liste, pour l'export des donnees de l'utilisateur
+func (m *WatchlistModel) ExportForUser(userID int64) ([]*WatchlistEntry, error) {
+	query := `
+		SELECT COUNT(*) OVER(), w.movie_id, m.title, m.year, w.added_at
+		FROM watchlist as w
+		INNER JOIN movies as m
+		ON m.id = w.movie_id
+		WHERE w.user_id = $1
+		AND m.deleted_at IS NULL
+		ORDER BY w.added_at, w.movie_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries, _, err := scanWatchlist(rows)
+	return entries, err
+}
+
+func scanWatchlist(rows *sql.Rows) ([]*WatchlistEntry, int, error) {
+	entries := []*WatchlistEntry{}
+	totalRecords := 0
+	for rows.Next() {
+		var entry WatchlistEntry
+
+		err := rows.Scan(&totalRecords, &entry.MovieID, &entry.Title, &entry.Year, &entry.AddedAt)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return entries, totalRecords, nil
+}
+
+func (m *WatchedModel) Insert(userID int64, entry *WatchedEntry) error {
+	query := `
+		WITH inserted as (
+			INSERT INTO watched (user_id, movie_id, watched_on, rating)
+			SELECT $1::bigint, id, $3::date, $4::smallint FROM movies
+			WHERE id = $2 AND deleted_at IS NULL
+			RETURNING id, movie_id, created_at
+		)
+		SELECT i.id, m.title, m.year, i.created_at
+		FROM inserted as i
+		INNER JOIN movies as m
+		ON m.id = i.movie_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{userID, entry.MovieID, entry.WatchedOn, entry.Rating}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&entry.ID, &entry.Title, &entry.Year, &entry.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		pqErr, ok := err.(*pq.Error)
+		if ok && pqErr.Code.Name() == "foreign_key_violation" {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (m *WatchedModel) DeleteForUser(id int64, userID int64) error {
+	query := `
+		DELETE FROM watched
+		WHERE id = $1
+		AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m *WatchedModel) GetAllForUser(userID int64, filters Filters) ([]*WatchedEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), w.id, w.movie_id, m.title, m.year, w.watched_on, w.rating, w.created_at
+		FROM watched as w
+		INNER JOIN movies as m
+		ON m.id = w.movie_id
+		WHERE w.user_id = $1
+		AND m.deleted_at IS NULL
+		ORDER BY %s %s, w.id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	entries, totalRecords, err := scanWatched(rows)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return entries, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Tout l'historique, pour l'export des donnees de l'utilisateur
+func (m *WatchedModel) ExportForUser(userID int64) ([]*WatchedEntry, error) {
+	query := `
+		SELECT COUNT(*) OVER(), w.id, w.movie_id, m.title, m.year, w.watched_on, w.rating, w.created_at
+		FROM watched as w
+		INNER JOIN movies as m
+		ON m.id = w.movie_id
+		WHERE w.user_id = $1
+		AND m.deleted_at IS NULL
+		ORDER BY w.watched_on, w.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries, _, err := scanWatched(rows)
+	return entries, err
+}
+
+func scanWatched(rows *sql.Rows) ([]*WatchedEntry, int, error) {
+	entries := []*WatchedEntry{}
+	totalRecords := 0
+	for rows.Next() {
+		var entry WatchedEntry
+		var rating sql.NullInt32
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.ID,
+			&entry.MovieID,
+			&entry.Title,
+			&entry.Year,
+			&entry.WatchedOn,
+			&rating,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if rating.Valid {
+			value := int(rating.Int32)
+			entry.Rating = &value
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return entries, totalRecords, nil
+}
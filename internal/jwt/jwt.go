@@ -0,0 +1,224 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Algorithmes supportes
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("expired token")
+	ErrUnknownKey   = errors.New("unknown signing key")
+)
+
+var encoding = base64.RawURLEncoding
+
+// Ce que l'on met dans le token pour eviter d'aller a la base de donnees
+type Claims struct {
+	Subject     string   `json:"sub"`
+	Issuer      string   `json:"iss,omitempty"`
+	IssuedAt    int64    `json:"iat"`
+	Expiry      int64    `json:"exp"`
+	Name        string   `json:"name,omitempty"`
+	Email       string   `json:"email,omitempty"`
+	Activated   bool     `json:"activated"`
+	Permissions []string `json:"permissions"`
+	Family      string   `json:"fam,omitempty"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+type Key struct {
+	ID        string
+	Algorithm string
+	secret    []byte
+	private   ed25519.PrivateKey
+	public    ed25519.PublicKey
+}
+
+// A KeySet signs with its active key and verifies with any key it knows,
+// so old keys can stay around until the tokens they signed have expired.
+type KeySet struct {
+	keys   map[string]*Key
+	active string
+	issuer string
+}
+
+// Parses "kid:alg:base64key" entries separated by commas. The key is the raw
+// secret for HS256 and the 32 bytes seed for EdDSA.
+func ParseKeys(spec string, activeKeyID string, issuer string) (*KeySet, error) {
+	ks := &KeySet{
+		keys:   make(map[string]*Key),
+		active: activeKeyID,
+		issuer: issuer,
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("jwt: invalid key entry %q", entry)
+		}
+
+		material, err := base64.StdEncoding.DecodeString(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("jwt: key %q is not valid base64", parts[0])
+		}
+
+		var key *Key = &Key{ID: parts[0], Algorithm: parts[1]}
+
+		switch key.Algorithm {
+		case AlgorithmHS256:
+			if len(material) < 32 {
+				return nil, fmt.Errorf("jwt: HS256 key %q must be at least 32 bytes long", key.ID)
+			}
+			key.secret = material
+		case AlgorithmEdDSA:
+			if len(material) != ed25519.SeedSize {
+				return nil, fmt.Errorf("jwt: EdDSA key %q must be a %d bytes seed", key.ID, ed25519.SeedSize)
+			}
+			key.private = ed25519.NewKeyFromSeed(material)
+			key.public = key.private.Public().(ed25519.PublicKey)
+		default:
+			return nil, fmt.Errorf("jwt: unsupported algorithm %q for key %q", key.Algorithm, key.ID)
+		}
+
+		if _, exists := ks.keys[key.ID]; exists {
+			return nil, fmt.Errorf("jwt: duplicate key id %q", key.ID)
+		}
+		ks.keys[key.ID] = key
+	}
+
+	if _, ok := ks.keys[activeKeyID]; !ok {
+		return nil, fmt.Errorf("jwt: active key %q is not in the key set", activeKeyID)
+	}
+
+	return ks, nil
+}
+
+// Returns true if the value looks like a compact JWS (header.payload.signature)
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+func (ks *KeySet) Sign(claims Claims, ttl time.Duration) (string, time.Time, error) {
+	key := ks.keys[ks.active]
+
+	now := time.Now()
+	expiry := now.Add(ttl)
+
+	claims.Issuer = ks.issuer
+	claims.IssuedAt = now.Unix()
+	claims.Expiry = expiry.Unix()
+
+	headerJSON, err := json.Marshal(header{Algorithm: key.Algorithm, Type: "JWT", KeyID: key.ID})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	signingInput := encoding.EncodeToString(headerJSON) + "." + encoding.EncodeToString(claimsJSON)
+
+	return signingInput + "." + encoding.EncodeToString(key.sign([]byte(signingInput))), expiry, nil
+}
+
+func (ks *KeySet) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	headerJSON, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	if err = json.Unmarshal(headerJSON, &h); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key, ok := ks.keys[h.KeyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	// L'algorithme vient de la cle, jamais du token (pas de "alg": "none")
+	if h.Algorithm != key.Algorithm {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidToken
+	}
+
+	claimsJSON, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err = json.Unmarshal(claimsJSON, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.Issuer != ks.issuer {
+		return nil, ErrInvalidToken
+	}
+
+	if time.Now().Unix() >= claims.Expiry {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
+
+func (k *Key) sign(input []byte) []byte {
+	switch k.Algorithm {
+	case AlgorithmEdDSA:
+		return ed25519.Sign(k.private, input)
+	default:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		return mac.Sum(nil)
+	}
+}
+
+func (k *Key) verify(input []byte, signature []byte) bool {
+	switch k.Algorithm {
+	case AlgorithmEdDSA:
+		return ed25519.Verify(k.public, input, signature)
+	default:
+		return hmac.Equal(k.sign(input), signature)
+	}
+}
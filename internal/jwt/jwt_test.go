@@ -0,0 +1,170 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	hsKey = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	edKey = base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+)
+
+func mustParseKeys(t *testing.T, spec string, active string, issuer string) *KeySet {
+	t.Helper()
+
+	ks, err := ParseKeys(spec, active, issuer)
+	if err != nil {
+		t.Fatalf("ParseKeys: %v", err)
+	}
+	return ks
+}
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	for _, alg := range []string{AlgorithmHS256, AlgorithmEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			material := hsKey
+			if alg == AlgorithmEdDSA {
+				material = edKey
+			}
+			ks := mustParseKeys(t, "k1:"+alg+":"+material, "k1", "test")
+
+			signed, expiry, err := ks.Sign(Claims{Subject: "42", Permissions: []string{"movies:read"}, Family: "fam"}, time.Minute)
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+
+			if !IsJWT(signed) {
+				t.Fatalf("IsJWT(%q) = false", signed)
+			}
+
+			claims, err := ks.Verify(signed)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+
+			if claims.Subject != "42" || claims.Family != "fam" || claims.Issuer != "test" {
+				t.Errorf("unexpected claims %+v", claims)
+			}
+			if len(claims.Permissions) != 1 || claims.Permissions[0] != "movies:read" {
+				t.Errorf("unexpected permissions %v", claims.Permissions)
+			}
+			if claims.Expiry != expiry.Unix() {
+				t.Errorf("exp = %d, want %d", claims.Expiry, expiry.Unix())
+			}
+		})
+	}
+}
+
+func TestVerifyOldKeyAfterRotation(t *testing.T) {
+	old := mustParseKeys(t, "k1:HS256:"+hsKey, "k1", "test")
+	signed, _, err := old.Sign(Claims{Subject: "1"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := mustParseKeys(t, "k1:HS256:"+hsKey+",k2:EdDSA:"+edKey, "k2", "test")
+	if _, err := rotated.Verify(signed); err != nil {
+		t.Errorf("Verify with the old key still in the set: %v", err)
+	}
+}
+
+func TestVerifyRejectsTamperedSignature(t *testing.T) {
+	ks := mustParseKeys(t, "k1:HS256:"+hsKey, "k1", "test")
+	signed, _, err := ks.Sign(Claims{Subject: "1"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(signed, ".")
+	forged, _, err := ks.Sign(Claims{Subject: "2"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts[1] = strings.Split(forged, ".")[1]
+
+	if _, err := ks.Verify(strings.Join(parts, ".")); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify(swapped payload) = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestVerifyAlgorithmMismatch(t *testing.T) {
+	ks := mustParseKeys(t, "k1:EdDSA:"+edKey, "k1", "test")
+	signed, _, err := ks.Sign(Claims{Subject: "1"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(signed, ".")
+
+	for _, alg := range []string{"none", AlgorithmHS256} {
+		parts[0] = encoding.EncodeToString([]byte(`{"alg":"` + alg + `","typ":"JWT","kid":"k1"}`))
+
+		if _, err := ks.Verify(strings.Join(parts, ".")); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Verify(alg=%s) = %v, want ErrInvalidToken", alg, err)
+		}
+	}
+}
+
+func TestVerifyUnknownKeyID(t *testing.T) {
+	signer := mustParseKeys(t, "k1:HS256:"+hsKey, "k1", "test")
+	verifier := mustParseKeys(t, "k2:HS256:"+hsKey, "k2", "test")
+
+	signed, _, err := signer.Sign(Claims{Subject: "1"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := verifier.Verify(signed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Verify = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestVerifyExpired(t *testing.T) {
+	ks := mustParseKeys(t, "k1:HS256:"+hsKey, "k1", "test")
+
+	signed, _, err := ks.Sign(Claims{Subject: "1"}, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ks.Verify(signed); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("Verify = %v, want ErrExpiredToken", err)
+	}
+}
+
+func TestVerifyWrongIssuer(t *testing.T) {
+	signer := mustParseKeys(t, "k1:HS256:"+hsKey, "k1", "someone-else")
+	verifier := mustParseKeys(t, "k1:HS256:"+hsKey, "k1", "test")
+
+	signed, _, err := signer.Sign(Claims{Subject: "1"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := verifier.Verify(signed); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestParseKeysRejectsBadEntries(t *testing.T) {
+	short := base64.StdEncoding.EncodeToString([]byte("short"))
+
+	tests := map[string]string{
+		"short HS256 secret":    "k1:HS256:" + short,
+		"bad EdDSA seed":        "k1:EdDSA:" + short,
+		"unknown algorithm":     "k1:RS256:" + hsKey,
+		"invalid base64":        "k1:HS256:***",
+		"missing part":          "k1:" + hsKey,
+		"duplicate key id":      "k1:HS256:" + hsKey + ",k1:EdDSA:" + edKey,
+		"active key not in set": "k2:HS256:" + hsKey,
+	}
+
+	for name, spec := range tests {
+		if _, err := ParseKeys(spec, "k1", "test"); err == nil {
+			t.Errorf("%s: ParseKeys(%q) succeeded", name, spec)
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidIDToken = errors.New("oidc: invalid id token")
+	ErrExchangeFailed = errors.New("oidc: code exchange failed")
+)
+
+var encoding = base64.RawURLEncoding
+
+// Un kid inconnu ne recharge pas les cles plus d'une fois par intervalle: le callback
+// n'est pas authentifie, personne ne doit pouvoir faire marteler le fournisseur
+const keysRefreshInterval = time.Minute
+
+// Les endpoints publies par le fournisseur d'identite
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type Provider struct {
+	issuer                string
+	authorizationEndpoint string
+	tokenEndpoint         string
+	jwksURI               string
+
+	clientID     string
+	clientSecret string
+	redirectURL  string
+
+	client *http.Client
+
+	mutex sync.RWMutex
+	keys  map[string]*rsa.PublicKey
+
+	refreshMutex sync.Mutex
+	lastRefresh  time.Time
+}
+
+// Ce que l'on lit dans l'ID token
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// "aud" peut etre une chaine ou une liste
+type audience []string
+
+func (a *audience) UnmarshalJSON(value []byte) error {
+	var single string
+	if err := json.Unmarshal(value, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(value, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// Discover reads the provider configuration from the issuer's
+// /.well-known/openid-configuration document.
+func Discover(ctx context.Context, issuer, clientID, clientSecret, redirectURL string) (*Provider, error) {
+	p := &Provider{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		client:       &http.Client{Timeout: 5 * time.Second},
+		keys:         make(map[string]*rsa.PublicKey),
+	}
+
+	var document discoveryDocument
+	err := p.getJSON(ctx, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &document)
+	if err != nil {
+		return nil, err
+	}
+
+	// Le document doit annoncer exactement l'issuer configure
+	if document.Issuer != issuer {
+		return nil, fmt.Errorf("oidc: issuer mismatch, expected %q got %q", issuer, document.Issuer)
+	}
+
+	if document.AuthorizationEndpoint == "" || document.TokenEndpoint == "" || document.JWKSURI == "" {
+		return nil, errors.New("oidc: incomplete discovery document")
+	}
+
+	p.issuer = document.Issuer
+	p.authorizationEndpoint = document.AuthorizationEndpoint
+	p.tokenEndpoint = document.TokenEndpoint
+	p.jwksURI = document.JWKSURI
+
+	return p, nil
+}
+
+func (p *Provider) Issuer() string {
+	return p.issuer
+}
+
+// Une valeur aleatoire pour state, nonce et le code verifier PKCE
+func RandomString() (string, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(randomBytes), nil
+}
+
+// Le code challenge PKCE (methode S256) d'un code verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return encoding.EncodeToString(sum[:])
+}
+
+func (p *Provider) AuthCodeURL(state, nonce, codeVerifier string) string {
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.clientID)
+	query.Set("redirect_uri", p.redirectURL)
+	query.Set("scope", "openid email profile")
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", CodeChallenge(codeVerifier))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(p.authorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return p.authorizationEndpoint + separator + query.Encode()
+}
+
+// Exchange trades the authorization code for tokens and returns the verified
+// claims of the ID token.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Claims, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirectURL)
+	form.Set("client_id", p.clientID)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: token endpoint returned %s", ErrExchangeFailed, res.Status)
+	}
+
+	var body struct {
+		IDToken string `json:"id_token"`
+	}
+	err = json.NewDecoder(io.LimitReader(res.Body, 1_048_576)).Decode(&body)
+	if err != nil {
+		return nil, err
+	}
+
+	if body.IDToken == "" {
+		return nil, fmt.Errorf("%w: no id_token in the response", ErrExchangeFailed)
+	}
+
+	return p.Verify(ctx, body.IDToken, nonce)
+}
+
+// Verify checks the RS256 signature against the provider keys, then the
+// issuer, the audience, the expiry and the nonce.
+func (p *Provider) Verify(ctx context.Context, rawIDToken string, nonce string) (*Claims, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidIDToken
+	}
+
+	headerJSON, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err = json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	if header.Algorithm != "RS256" {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidIDToken, header.Algorithm)
+	}
+
+	key, err := p.key(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	claimsJSON, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	var claims Claims
+	if err = json.Unmarshal(claimsJSON, &claims); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	switch {
+	case claims.Issuer != p.issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer", ErrInvalidIDToken)
+	case !claims.Audience.contains(p.clientID):
+		return nil, fmt.Errorf("%w: unexpected audience", ErrInvalidIDToken)
+	case time.Now().Unix() >= claims.Expiry:
+		return nil, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+
+	return &claims, nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, value := range a {
+		if value == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// Les cles sont gardees en memoire, et rechargees quand un kid inconnu arrive (rotation),
+// au plus une fois par keysRefreshInterval
+func (p *Provider) key(ctx context.Context, keyID string) (*rsa.PublicKey, error) {
+	p.mutex.RLock()
+	key, found := p.keys[keyID]
+	p.mutex.RUnlock()
+
+	if found {
+		return key, nil
+	}
+
+	p.refreshMutex.Lock()
+	if time.Since(p.lastRefresh) >= keysRefreshInterval {
+		// Meme un echec compte, pour ne pas reessayer a chaque requete
+		p.lastRefresh = time.Now()
+
+		err := p.refreshKeys(ctx)
+		if err != nil {
+			p.refreshMutex.Unlock()
+			return nil, err
+		}
+	}
+	p.refreshMutex.Unlock()
+
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	key, found = p.keys[keyID]
+	if !found {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidIDToken, keyID)
+	}
+	return key, nil
+}
+
+func (p *Provider) refreshKeys(ctx context.Context) error {
+	var jwks struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			Use     string `json:"use"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+
+	err := p.getJSON(ctx, p.jwksURI, &jwks)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.KeyType != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+
+		n, err := encoding.DecodeString(jwk.N)
+		if err != nil {
+			continue
+		}
+		e, err := encoding.DecodeString(jwk.E)
+		if err != nil {
+			continue
+		}
+
+		keys[jwk.KeyID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	p.mutex.Lock()
+	p.keys = keys
+	p.mutex.Unlock()
+
+	return nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, destination interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: GET %s returned %s", url, res.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, 1_048_576)).Decode(destination)
+}
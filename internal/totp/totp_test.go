@@ -0,0 +1,89 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// Le secret SHA-1 de l'annexe B de RFC 6238
+var rfcSecret = []byte("12345678901234567890")
+
+// RFC 6238 annexe B, SHA-1. Les vecteurs ont 8 chiffres, nos codes en gardent 6.
+func TestCodeRFC6238Vectors(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		want := tt.code[len(tt.code)-Digits:]
+		if got := Code(rfcSecret, time.Unix(tt.unix, 0)); got != want {
+			t.Errorf("Code(T=%d) = %s, want %s", tt.unix, got, want)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name   string
+		offset int64
+		skew   int64
+		valid  bool
+	}{
+		{"current step", 0, 0, true},
+		{"previous step without skew", -1, 0, false},
+		{"previous step", -1, 1, true},
+		{"next step", 1, 1, true},
+		{"two steps behind", -2, 1, false},
+		{"two steps ahead", 2, 1, false},
+	}
+
+	for _, tt := range tests {
+		code := codeAt(rfcSecret, current+tt.offset)
+
+		step, ok := Validate(rfcSecret, code, now, tt.skew)
+		if ok != tt.valid {
+			t.Errorf("%s: Validate ok = %v, want %v", tt.name, ok, tt.valid)
+			continue
+		}
+		if ok && step != current+tt.offset {
+			t.Errorf("%s: Validate step = %d, want %d", tt.name, step, current+tt.offset)
+		}
+	}
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(59, 0)
+
+	for _, code := range []string{"", "28708", "2870820", "94287082"} {
+		if _, ok := Validate(rfcSecret, code, now, 1); ok {
+			t.Errorf("Validate(%q) accepted", code)
+		}
+	}
+}
+
+// Le meme code dans la meme periode donne la meme step: UseStep peut refuser le rejeu
+func TestValidateReturnsStepForReplayDetection(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code := Code(rfcSecret, now)
+
+	first, ok := Validate(rfcSecret, code, now, 1)
+	if !ok {
+		t.Fatal("Validate rejected the current code")
+	}
+
+	second, ok := Validate(rfcSecret, code, now.Add(Period/2), 1)
+	if !ok || second != first {
+		t.Errorf("Validate replay = (%d, %v), want (%d, true)", second, ok, first)
+	}
+}
@@ -0,0 +1,6 @@
+-- Tous les tokens emis par un meme login partagent la meme famille
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS family bytea;
+-- Un refresh token deja utilise est garde pour detecter sa reutilisation
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS used_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS tokens_family_idx ON tokens (family);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS api_keys(
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    name text NOT NULL,
+    prefix text NOT NULL UNIQUE, -- la partie publique de la cle, pour la retrouver
+    hash bytea NOT NULL, -- sha256 du secret
+    permissions text[] NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    last_used_at timestamp(0) with time zone,
+    expiry timestamp(0) with time zone, -- NULL: la cle n'expire pas
+    CONSTRAINT api_keys_user_name_key UNIQUE (user_id, name)
+);
@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS roles(
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    name text NOT NULL UNIQUE,
+    description text NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions(
+    role_id bigint NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS users_roles(
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role_id bigint NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO permissions(code) VALUES ('roles:admin');
+
+INSERT INTO roles(name, description) VALUES ('viewer', 'Can browse the catalogue');
+INSERT INTO roles(name, description) VALUES ('editor', 'Can browse and edit the catalogue');
+INSERT INTO roles(name, description) VALUES ('admin', 'Can do everything, including managing roles');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles as r, permissions as p
+WHERE (r.name = 'viewer' AND p.code = 'movies:read')
+OR (r.name = 'editor' AND p.code IN ('movies:read', 'movies:write'))
+OR (r.name = 'admin' AND p.code IN ('movies:read', 'movies:write', 'roles:admin'));
@@ -0,0 +1,7 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locked bool NOT NULL DEFAULT false;
+
+INSERT INTO permissions(code) VALUES ('users:admin');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles as r, permissions as p
+WHERE r.name = 'admin' AND p.code = 'users:admin';
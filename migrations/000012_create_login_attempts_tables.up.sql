@@ -0,0 +1,15 @@
+-- Les echecs sont gardes par email (existant ou non) et par IP
+CREATE TABLE IF NOT EXISTS login_failures(
+    id bigserial PRIMARY KEY,
+    email citext NOT NULL,
+    ip text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS login_failures_email_created_at_idx ON login_failures (email, created_at);
+
+CREATE TABLE IF NOT EXISTS login_lockouts(
+    email citext PRIMARY KEY,
+    locked_until timestamp(0) with time zone NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now()
+);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS users_totp(
+    user_id bigint PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret bytea NOT NULL,
+    confirmed bool NOT NULL DEFAULT false, -- false tant que le premier code n'a pas ete verifie
+    last_used_step bigint NOT NULL DEFAULT 0, -- un code ne peut pas etre utilise deux fois
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes(
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    hash bytea NOT NULL,
+    used_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS recovery_codes_user_id_idx ON recovery_codes (user_id);
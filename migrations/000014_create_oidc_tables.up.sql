@@ -0,0 +1,18 @@
+-- Une connexion OIDC en cours, entre la redirection et le callback
+CREATE TABLE IF NOT EXISTS oidc_states(
+    hash bytea PRIMARY KEY,
+    code_verifier text NOT NULL,
+    nonce text NOT NULL,
+    expiry timestamp(0) with time zone NOT NULL
+);
+
+-- Le compte chez le fournisseur d'identite (iss, sub) lie a un utilisateur
+CREATE TABLE IF NOT EXISTS users_identities(
+    issuer text NOT NULL,
+    subject text NOT NULL,
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS users_identities_user_id_idx ON users_identities (user_id);
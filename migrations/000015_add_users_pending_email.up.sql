@@ -0,0 +1,2 @@
+-- La nouvelle adresse attend d'etre confirmee avant de remplacer email
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_email citext;
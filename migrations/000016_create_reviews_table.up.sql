@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS reviews(
+    id bigserial PRIMARY KEY,
+    movie_id bigint NOT NULL REFERENCES movies(id) ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    rating smallint NOT NULL CHECK (rating BETWEEN 1 AND 10),
+    body text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (movie_id, user_id) -- une critique par utilisateur et par film
+);
+
+CREATE INDEX IF NOT EXISTS reviews_user_id_idx ON reviews (user_id);
@@ -0,0 +1,19 @@
+-- Les films qu'un utilisateur veut voir
+CREATE TABLE IF NOT EXISTS watchlist(
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies(id) ON DELETE CASCADE,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, movie_id)
+);
+
+-- Les films vus, un film peut etre vu plusieurs fois
+CREATE TABLE IF NOT EXISTS watched(
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies(id) ON DELETE CASCADE,
+    watched_on date NOT NULL DEFAULT CURRENT_DATE,
+    rating smallint CHECK (rating BETWEEN 1 AND 10), -- optionnelle
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS watched_user_id_idx ON watched (user_id);
@@ -0,0 +1,52 @@
+-- Les slugs peuvent etre dans n'importe quelle ecriture ("アクション"), voir data.NormalizeGenre
+CREATE TABLE IF NOT EXISTS genres(
+    slug text PRIMARY KEY CHECK (slug ~ '^[^-[:space:]]+(-[^-[:space:]]+)*$' AND slug = lower(slug)),
+    name text NOT NULL
+);
+
+-- Les alias sont normalises comme les slugs
+CREATE TABLE IF NOT EXISTS genre_aliases(
+    alias text PRIMARY KEY CHECK (alias ~ '^[^-[:space:]]+(-[^-[:space:]]+)*$' AND alias = lower(alias)),
+    slug text NOT NULL REFERENCES genres(slug) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+INSERT INTO genres (slug, name)
+VALUES
+    ('action', 'Action'),
+    ('adventure', 'Adventure'),
+    ('animation', 'Animation'),
+    ('biography', 'Biography'),
+    ('comedy', 'Comedy'),
+    ('crime', 'Crime'),
+    ('documentary', 'Documentary'),
+    ('drama', 'Drama'),
+    ('family', 'Family'),
+    ('fantasy', 'Fantasy'),
+    ('history', 'History'),
+    ('horror', 'Horror'),
+    ('music', 'Music'),
+    ('musical', 'Musical'),
+    ('mystery', 'Mystery'),
+    ('romance', 'Romance'),
+    ('science-fiction', 'Science Fiction'),
+    ('sport', 'Sport'),
+    ('thriller', 'Thriller'),
+    ('war', 'War'),
+    ('western', 'Western')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO genre_aliases (alias, slug)
+VALUES
+    ('sci-fi', 'science-fiction'),
+    ('scifi', 'science-fiction'),
+    ('sf', 'science-fiction'),
+    ('animated', 'animation'),
+    ('anime', 'animation'),
+    ('cartoon', 'animation'),
+    ('biopic', 'biography'),
+    ('docu', 'documentary'),
+    ('historical', 'history'),
+    ('romantic', 'romance'),
+    ('sports', 'sport'),
+    ('suspense', 'thriller')
+ON CONFLICT DO NOTHING;
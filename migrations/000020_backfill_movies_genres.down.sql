@@ -0,0 +1,8 @@
+UPDATE movies
+SET genres = b.genres, version = movies.version + 1
+FROM movies_genres_backup as b
+WHERE movies.id = b.movie_id
+AND b.mapped
+AND movies.genres IS DISTINCT FROM b.genres;
+
+DROP TABLE IF EXISTS movies_genres_backup;
@@ -0,0 +1,68 @@
+-- Meme normalisation que data.NormalizeGenre, pour les genres ASCII seulement :
+-- lower() et les classes de caracteres dependent de la locale pour le reste
+CREATE OR REPLACE FUNCTION pg_temp.normalize_genre(value text) RETURNS text AS $$
+    SELECT trim(both '-' from regexp_replace(lower(value), '[^a-z0-9]+', '-', 'g'))
+$$ LANGUAGE sql IMMUTABLE;
+
+-- Un film n'est reecrit que si tous ses genres se normalisent en SQL
+CREATE OR REPLACE FUNCTION pg_temp.genres_mappable(genres text[]) RETURNS bool AS $$
+    SELECT cardinality(genres) > 0 AND NOT EXISTS (
+        SELECT 1 FROM unnest(genres) as g
+        WHERE g !~ '^[[:ascii:]]*$' OR pg_temp.normalize_genre(g) = ''
+    )
+$$ LANGUAGE sql IMMUTABLE;
+
+-- Les genres d'origine, pour la migration down et pour les films laisses tels quels
+CREATE TABLE IF NOT EXISTS movies_genres_backup(
+    movie_id bigint PRIMARY KEY REFERENCES movies(id) ON DELETE CASCADE,
+    genres text[] NOT NULL,
+    mapped bool NOT NULL -- false : genres non normalisables, le film n'a pas ete modifie
+);
+
+INSERT INTO movies_genres_backup (movie_id, genres, mapped)
+SELECT id, genres, pg_temp.genres_mappable(genres)
+FROM movies
+ON CONFLICT DO NOTHING;
+
+-- Les genres inconnus entrent dans le catalogue plutot que d'etre perdus
+INSERT INTO genres (slug, name)
+SELECT DISTINCT ON (pg_temp.normalize_genre(g)) pg_temp.normalize_genre(g), initcap(trim(g))
+FROM movies, unnest(movies.genres) as g
+WHERE pg_temp.genres_mappable(movies.genres)
+AND NOT EXISTS (SELECT 1 FROM genre_aliases as a WHERE a.alias = pg_temp.normalize_genre(g))
+ORDER BY pg_temp.normalize_genre(g), g
+ON CONFLICT DO NOTHING;
+
+-- Remplace chaque genre par son slug, sans doublon, en gardant l'ordre d'origine
+WITH canonical as (
+    SELECT m.id, ARRAY(
+        SELECT c.slug
+        FROM (
+            SELECT COALESCE(a.slug, pg_temp.normalize_genre(u.g)) as slug, MIN(u.ord) as ord
+            FROM unnest(m.genres) WITH ORDINALITY as u(g, ord)
+            LEFT JOIN genre_aliases as a
+            ON a.alias = pg_temp.normalize_genre(u.g)
+            GROUP BY 1
+        ) as c
+        ORDER BY c.ord
+    ) as genres
+    FROM movies as m
+    WHERE pg_temp.genres_mappable(m.genres)
+)
+UPDATE movies
+SET genres = canonical.genres, version = movies.version + 1
+FROM canonical
+WHERE movies.id = canonical.id
+AND movies.genres IS DISTINCT FROM canonical.genres;
+
+-- Les films restants sont listes dans movies_genres_backup (mapped = false)
+DO $$
+DECLARE
+    skipped bigint;
+BEGIN
+    SELECT count(*) INTO skipped FROM movies_genres_backup WHERE NOT mapped;
+    IF skipped > 0 THEN
+        RAISE NOTICE '% movies kept their original genres, see movies_genres_backup', skipped;
+    END IF;
+END
+$$;
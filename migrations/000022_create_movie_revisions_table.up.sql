@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS movie_revisions(
+    movie_id bigint NOT NULL REFERENCES movies(id) ON DELETE CASCADE,
+    version integer NOT NULL,
+    title text NOT NULL,
+    year integer NOT NULL,
+    runtime integer NOT NULL,
+    genres text[] NOT NULL,
+    changed_by bigint REFERENCES users(id) ON DELETE SET NULL, -- NULL si inconnu ou supprime
+    changed_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (movie_id, version)
+);
+
+-- L'etat actuel des films existants devient leur premiere revision connue
+INSERT INTO movie_revisions (movie_id, version, title, year, runtime, genres, changed_at)
+SELECT id, version, title, year, runtime, genres, created_at
+FROM movies
+ON CONFLICT DO NOTHING;
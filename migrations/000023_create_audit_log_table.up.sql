@@ -0,0 +1,32 @@
+-- Pas de cle etrangere sur actor_id : l'historique survit a la suppression du compte
+CREATE TABLE IF NOT EXISTS audit_log(
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    actor_id bigint,
+    action text NOT NULL,
+    resource_type text NOT NULL,
+    resource_id text NOT NULL DEFAULT '',
+    request_id text NOT NULL DEFAULT '',
+    ip text NOT NULL DEFAULT '',
+    before jsonb,
+    after jsonb
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at);
+CREATE INDEX IF NOT EXISTS audit_log_actor_id_idx ON audit_log (actor_id);
+CREATE INDEX IF NOT EXISTS audit_log_resource_idx ON audit_log (resource_type, resource_id);
+
+-- Le journal est en ajout seul
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_no_update_or_delete
+BEFORE UPDATE OR DELETE ON audit_log
+FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+CREATE TRIGGER audit_log_no_truncate
+BEFORE TRUNCATE ON audit_log
+FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();